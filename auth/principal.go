@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"go-chat-application/internal/database"
+	"go-chat-application/tokenPackage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID primitive.ObjectID
+	User   database.User
+	Claims *tokenPackage.Claims
+}
+
+type contextKey string
+
+var principalContextKey contextKey = "principal"
+
+// WithPrincipal returns a copy of the context that carries the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the principal stored in the context, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// TokenRevoked reports whether the token was issued before the user revoked their
+// tokens. Token times only have second precision, so a token issued within the
+// second of the revocation is left to the token store, which knows every token
+// issued since typed claims were introduced. Old-format tokens without an issue
+// time count as issued before any revocation.
+func TokenRevoked(user database.User, claims *tokenPackage.Claims) bool {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	revokedAt := user.TokensRevokedAt
+	if claims.Legacy() && user.LegacyTokensRevokedAt.After(revokedAt) {
+		revokedAt = user.LegacyTokensRevokedAt
+	}
+	return !revokedAt.IsZero() && issuedAt.Before(revokedAt.Truncate(time.Second))
+}
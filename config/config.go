@@ -1,16 +1,262 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"go-chat-application/internal/database"
+	"go-chat-application/logging"
+	"go-chat-application/tracing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-type ApiConfig struct {
-	DB        *database.MongoDBClient
-	JwtSecret string
+// Config holds the settings of one application instance. It is filled from the
+// defaults, a YAML or TOML file, environment variables and command-line flags,
+// in that order of precedence, and validated before the application starts.
+type Config struct {
+	Port        string `yaml:"port" toml:"port"`
+	DatabaseURL string `yaml:"database_url" toml:"database_url"`
+
+	// JwtSecret signs tokens with HS256 when no signing keys are configured.
+	JwtSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+	// JwtKeysDir holds "<kid>.pem" signing and verification keys.
+	JwtKeysDir string `yaml:"jwt_keys_dir" toml:"jwt_keys_dir"`
+	// JwtSigningKeyID names the key in JwtKeysDir that signs new tokens.
+	JwtSigningKeyID string `yaml:"jwt_signing_key_id" toml:"jwt_signing_key_id"`
+
+	AccessExpiration         time.Duration `yaml:"access_expiration" toml:"access_expiration"`
+	RefreshExpiration        time.Duration `yaml:"refresh_expiration" toml:"refresh_expiration"`
+	RotateRefreshTokens      bool          `yaml:"rotate_refresh_tokens" toml:"rotate_refresh_tokens"`
+	LegacyTokenCompatibility bool          `yaml:"legacy_token_compatibility" toml:"legacy_token_compatibility"`
+	// LegacyTokenMaxAge is how long after they were issued old-format tokens are
+	// still accepted, which bounds the compatibility window.
+	LegacyTokenMaxAge time.Duration `yaml:"legacy_token_max_age" toml:"legacy_token_max_age"`
+
+	BcryptCost int `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins" toml:"cors_allowed_origins"`
+
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+
+	DatabaseConnectTimeout time.Duration `yaml:"database_connect_timeout" toml:"database_connect_timeout"`
+	// DatabaseTimeout bounds every database operation without its own timeout.
+	DatabaseTimeout time.Duration `yaml:"database_timeout" toml:"database_timeout"`
+	// DatabaseOperationTimeouts overrides DatabaseTimeout for single repository
+	// operations, keyed by method name, e.g. {"GetMessages": "10s"}.
+	DatabaseOperationTimeouts map[string]time.Duration `yaml:"database_operation_timeouts" toml:"database_operation_timeouts"`
+
+	// MigrateOnStartup applies the pending schema migrations before serving.
+	MigrateOnStartup bool `yaml:"migrate_on_startup" toml:"migrate_on_startup"`
+	// MigrationTimeout bounds how long applying or reverting migrations may take.
+	MigrationTimeout time.Duration `yaml:"migration_timeout" toml:"migration_timeout"`
+
+	// LogLevel is the minimum level of the JSON logs: debug, info, warn or error.
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+
+	// TracingExporter is where spans are sent: none, stdout or otlp.
+	TracingExporter string `yaml:"tracing_exporter" toml:"tracing_exporter"`
+	// TracingOTLPEndpoint is the host:port of the OTLP/HTTP collector.
+	TracingOTLPEndpoint string `yaml:"tracing_otlp_endpoint" toml:"tracing_otlp_endpoint"`
+	// TracingOTLPInsecure sends spans to the collector over plain HTTP.
+	TracingOTLPInsecure bool `yaml:"tracing_otlp_insecure" toml:"tracing_otlp_insecure"`
+
+	// HealthCheckTimeout bounds each dependency check made by the readiness endpoint.
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout" toml:"health_check_timeout"`
+
+	// ShutdownDelay is how long the server keeps serving with readiness failing
+	// before it stops accepting connections, so load balancers can route away.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+	// ShutdownTimeout bounds how long in-flight requests and open streams may
+	// take to finish once a shutdown signal is received.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// Default returns the configuration used for any setting that is not provided.
+func Default() Config {
+	return Config{
+		AccessExpiration:         time.Hour,
+		RefreshExpiration:        7 * (time.Hour * 24),
+		RotateRefreshTokens:      true,
+		LegacyTokenCompatibility: true,
+		LegacyTokenMaxAge:        7 * (time.Hour * 24),
+		BcryptCost:               bcrypt.DefaultCost,
+		CORSAllowedOrigins:       []string{"https://*", "http://*"},
+		ReadTimeout:              15 * time.Second,
+		ReadHeaderTimeout:        5 * time.Second,
+		WriteTimeout:             30 * time.Second,
+		IdleTimeout:              2 * time.Minute,
+		DatabaseConnectTimeout:   10 * time.Second,
+		DatabaseTimeout:          database.DefaultOperationTimeout,
+		MigrateOnStartup:         true,
+		MigrationTimeout:         5 * time.Minute,
+		LogLevel:                 "info",
+		TracingExporter:          tracing.ExporterNone,
+		TracingOTLPEndpoint:      "localhost:4318",
+		HealthCheckTimeout:       2 * time.Second,
+		ShutdownTimeout:          30 * time.Second,
+	}
 }
 
-var ApiCfg ApiConfig
+// problems collects the invalid settings found while validating a configuration.
+type problems []error
+
+// invalid records that the setting is invalid.
+func (p *problems) invalid(setting, format string, args ...interface{}) {
+	*p = append(*p, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+}
+
+// Validate checks every setting and returns all problems found at once.
+func (c Config) Validate() error {
+	var errs problems
+	invalid := errs.invalid
+
+	// The port must be a valid TCP port
+	if c.Port == "" {
+		invalid("port", "is required")
+	} else if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		invalid("port", "must be a number between 1 and 65535, got %q", c.Port)
+	}
 
-type ContextKey string
+	// The database settings are also checked on their own by ValidateDatabase
+	c.validateDatabase(invalid)
 
-var DBContextKey ContextKey = "db"
+	// Tokens are signed either with the JWT secret or with a key from the keys directory
+	if c.JwtKeysDir == "" && c.JwtSecret == "" {
+		invalid("jwt_secret", "is required when jwt_keys_dir is not set")
+	}
+	if c.JwtKeysDir != "" && c.JwtSigningKeyID == "" {
+		invalid("jwt_signing_key_id", "is required when jwt_keys_dir is set")
+	}
+	if c.JwtKeysDir == "" && c.JwtSigningKeyID != "" {
+		invalid("jwt_signing_key_id", "requires jwt_keys_dir to be set")
+	}
+
+	// Token lifetimes must be positive, and refresh tokens must outlive access tokens
+	if c.AccessExpiration <= 0 {
+		invalid("access_expiration", "must be positive, got %s", c.AccessExpiration)
+	}
+	if c.RefreshExpiration <= c.AccessExpiration {
+		invalid("refresh_expiration", "must be longer than access_expiration (%s), got %s",
+			c.AccessExpiration, c.RefreshExpiration)
+	}
+	if c.LegacyTokenCompatibility && c.LegacyTokenMaxAge <= 0 {
+		invalid("legacy_token_max_age", "must be positive when legacy_token_compatibility is on, got %s",
+			c.LegacyTokenMaxAge)
+	}
+
+	// The bcrypt cost must be accepted by bcrypt
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		invalid("bcrypt_cost", "must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.BcryptCost)
+	}
+
+	// At least one origin must be allowed, and each must look like a URL pattern
+	if len(c.CORSAllowedOrigins) == 0 {
+		invalid("cors_allowed_origins", "must contain at least one origin")
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			invalid("cors_allowed_origins", "origin %q must start with http:// or https://", origin)
+		}
+	}
+
+	// Timeouts may be zero to disable them, but never negative
+	timeouts := map[string]time.Duration{
+		"read_timeout":        c.ReadTimeout,
+		"read_header_timeout": c.ReadHeaderTimeout,
+		"write_timeout":       c.WriteTimeout,
+		"idle_timeout":        c.IdleTimeout,
+		"shutdown_delay":      c.ShutdownDelay,
+	}
+	for _, name := range []string{"read_timeout", "read_header_timeout", "write_timeout", "idle_timeout",
+		"shutdown_delay"} {
+		if timeouts[name] < 0 {
+			invalid(name, "must not be negative, got %s", timeouts[name])
+		}
+	}
+
+	// The log level must be one slog understands
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		invalid("log_level", "must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+
+	// The span exporter must be known, and OTLP needs a collector to send to
+	switch c.TracingExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if c.TracingOTLPEndpoint == "" {
+			invalid("tracing_otlp_endpoint", "is required when tracing_exporter is otlp")
+		}
+	default:
+		invalid("tracing_exporter", "must be none, stdout or otlp, got %q", c.TracingExporter)
+	}
+
+	if c.HealthCheckTimeout <= 0 {
+		invalid("health_check_timeout", "must be positive, got %s", c.HealthCheckTimeout)
+	}
+	if c.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout", "must be positive, got %s", c.ShutdownTimeout)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateDatabase checks only the settings used to connect to and migrate the
+// database, for commands that do not run the server. It returns all problems
+// found at once.
+func (c Config) ValidateDatabase() error {
+	var errs problems
+	c.validateDatabase(errs.invalid)
+	return errors.Join(errs...)
+}
+
+// validateDatabase checks the database settings and reports each problem to invalid.
+func (c Config) validateDatabase(invalid func(setting, format string, args ...interface{})) {
+	// The database URL must be a MongoDB connection string
+	if c.DatabaseURL == "" {
+		invalid("database_url", "is required")
+	} else if !strings.HasPrefix(c.DatabaseURL, "mongodb://") && !strings.HasPrefix(c.DatabaseURL, "mongodb+srv://") {
+		invalid("database_url", "must start with mongodb:// or mongodb+srv://")
+	}
+
+	// Database timeouts must be positive
+	if c.DatabaseConnectTimeout <= 0 {
+		invalid("database_connect_timeout", "must be positive, got %s", c.DatabaseConnectTimeout)
+	}
+	if c.DatabaseTimeout <= 0 {
+		invalid("database_timeout", "must be positive, got %s", c.DatabaseTimeout)
+	}
+	if c.MigrationTimeout <= 0 {
+		invalid("migration_timeout", "must be positive, got %s", c.MigrationTimeout)
+	}
+	operations := make([]string, 0, len(c.DatabaseOperationTimeouts))
+	for operation := range c.DatabaseOperationTimeouts {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		if !database.IsOperation(operation) {
+			invalid("database_operation_timeouts", "unknown operation %q", operation)
+		} else if timeout := c.DatabaseOperationTimeouts[operation]; timeout <= 0 {
+			invalid("database_operation_timeouts", "timeout of %s must be positive, got %s", operation, timeout)
+		}
+	}
+}
+
+// DatabaseTimeouts returns the timeouts of the repository operations.
+func (c Config) DatabaseTimeouts() database.OperationTimeouts {
+	return database.OperationTimeouts{Default: c.DatabaseTimeout, Operations: c.DatabaseOperationTimeouts}
+}
+
+// Addr returns the address the HTTP server listens on.
+func (c Config) Addr() string {
+	return net.JoinHostPort("", c.Port)
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv hides the configuration variables of the environment from the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("CONFIG_FILE", "")
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+}
+
+// writeFile writes a configuration file with the given name and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+// valid returns a configuration that passes validation.
+func valid() Config {
+	cfg := Default()
+	cfg.Port = "8080"
+	cfg.DatabaseURL = "mongodb://localhost:27017/chat"
+	cfg.JwtSecret = "secret"
+	return cfg
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const yamlFile = `
+port: "8000"
+database_url: mongodb://file/chat
+jwt_secret: file-secret
+access_expiration: 30m
+bcrypt_cost: 11
+`
+	const tomlFile = `
+port = "8000"
+database_url = "mongodb://file/chat"
+jwt_secret = "file-secret"
+access_expiration = "30m"
+bcrypt_cost = 11
+`
+
+	tests := []struct {
+		name   string
+		file   string
+		env    map[string]string
+		args   []string
+		expect func(c *Config)
+	}{
+		{
+			name:   "yaml file",
+			file:   writeFile(t, "config.yaml", yamlFile),
+			expect: func(c *Config) {},
+		},
+		{
+			name:   "toml file",
+			file:   writeFile(t, "config.toml", tomlFile),
+			expect: func(c *Config) {},
+		},
+		{
+			name: "environment over file",
+			file: writeFile(t, "config.yml", yamlFile),
+			env:  map[string]string{"PORT": "9000", "ACCESS_EXPIRATION": "15m", "CORS_ALLOWED_ORIGINS": "https://a, https://b"},
+			expect: func(c *Config) {
+				c.Port, c.AccessExpiration = "9000", 15*time.Minute
+				c.CORSAllowedOrigins = []string{"https://a", "https://b"}
+			},
+		},
+		{
+			name: "flags over environment",
+			file: writeFile(t, "config.toml", tomlFile),
+			env:  map[string]string{"PORT": "9000", "BCRYPT_COST": "12"},
+			args: []string{"-port", "9500", "-database-operation-timeouts", "GetMessages=10s"},
+			expect: func(c *Config) {
+				c.Port, c.BcryptCost = "9500", 12
+				c.DatabaseOperationTimeouts = map[string]time.Duration{"GetMessages": 10 * time.Second}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("CONFIG_FILE", test.file)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			got, err := Load(test.args)
+			if err != nil {
+				t.Fatalf("loading configuration: %v", err)
+			}
+
+			// Settings the file does not mention keep their default
+			want := Default()
+			want.Port, want.DatabaseURL, want.JwtSecret = "8000", "mongodb://file/chat", "file-secret"
+			want.AccessExpiration, want.BcryptCost = 30*time.Minute, 11
+			test.expect(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "env.yaml", "port: \"1\"\n"))
+	path := writeFile(t, "flag.yaml", "port: \"2\"\ndatabase_url: mongodb://db/chat\njwt_secret: s\n")
+
+	// The -config flag takes precedence over CONFIG_FILE
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("loading configuration: %v", err)
+	}
+	if cfg.Port != "2" {
+		t.Errorf("got port %q, want 2", cfg.Port)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"unknown yaml setting", writeFile(t, "config.yaml", "prot: \"80\"\n"), nil, nil, "field prot not found"},
+		{"unknown toml setting", writeFile(t, "config.toml", "prot = \"80\"\n"), nil, nil, `unknown setting "prot"`},
+		{"unsupported file", writeFile(t, "config.json", "{}"), nil, nil, "unsupported extension"},
+		{"missing file", filepath.Join(t.TempDir(), "missing.yaml"), nil, nil, "reading configuration file"},
+		{"invalid environment", "", map[string]string{"ACCESS_EXPIRATION": "soon"}, nil,
+			`environment variable ACCESS_EXPIRATION: invalid duration "soon"`},
+		{"invalid flag", "", nil, []string{"-bcrypt-cost", "high"}, `flag -bcrypt-cost: invalid number "high"`},
+		{"unknown flag", "", nil, []string{"-color", "red"}, "flag provided but not defined"},
+		{"invalid result", "", map[string]string{"PORT": "80"}, nil, "invalid configuration:\ndatabase_url: is required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("CONFIG_FILE", test.file)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := Load(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"missing values", func(c *Config) { c.Port, c.DatabaseURL, c.JwtSecret = "", "", "" }, []string{
+			"port: is required",
+			"database_url: is required",
+			"jwt_secret: is required when jwt_keys_dir is not set",
+		}},
+		{"invalid values", func(c *Config) {
+			c.Port, c.DatabaseURL, c.BcryptCost = "http", "postgres://db", 99
+		}, []string{
+			`port: must be a number between 1 and 65535, got "http"`,
+			"database_url: must start with mongodb:// or mongodb+srv://",
+			"bcrypt_cost: must be between 4 and 31, got 99",
+		}},
+		{"keys without signing key", func(c *Config) { c.JwtSecret, c.JwtKeysDir = "", "keys" }, []string{
+			"jwt_signing_key_id: is required when jwt_keys_dir is set",
+		}},
+		{"expirations", func(c *Config) { c.AccessExpiration, c.RefreshExpiration = time.Hour, time.Hour }, []string{
+			"refresh_expiration: must be longer than access_expiration (1h0m0s), got 1h0m0s",
+		}},
+		{"legacy window", func(c *Config) { c.LegacyTokenMaxAge = 0 }, []string{
+			"legacy_token_max_age: must be positive when legacy_token_compatibility is on, got 0s",
+		}},
+		{"legacy tokens disabled", func(c *Config) { c.LegacyTokenCompatibility, c.LegacyTokenMaxAge = false, 0 }, nil},
+		{"origins", func(c *Config) { c.CORSAllowedOrigins = []string{"example.com"} }, []string{
+			`cors_allowed_origins: origin "example.com" must start with http:// or https://`,
+		}},
+		{"operation timeouts", func(c *Config) {
+			c.DatabaseOperationTimeouts = map[string]time.Duration{"GetMessages": -time.Second}
+		}, []string{
+			"database_operation_timeouts: timeout of GetMessages must be positive, got -1s",
+		}},
+		{"tracing", func(c *Config) { c.TracingExporter, c.LogLevel = "zipkin", "loud" }, []string{
+			`log_level: must be debug, info, warn or error, got "loud"`,
+			`tracing_exporter: must be none, stdout or otlp, got "zipkin"`,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := valid()
+			test.change(&cfg)
+
+			// Every problem is reported, one per line
+			err := cfg.Validate()
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", test.want)
+			}
+			if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got errors %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadDatabase(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DATABASE_URL", "mongodb://db/chat")
+	t.Setenv("LOG_LEVEL", "loud")
+
+	// Only the database settings have to be valid
+	cfg, err := LoadDatabase(nil)
+	if err != nil {
+		t.Fatalf("loading database configuration: %v", err)
+	}
+	if cfg.DatabaseURL != "mongodb://db/chat" {
+		t.Errorf("got database URL %q, want mongodb://db/chat", cfg.DatabaseURL)
+	}
+	if _, err := Load(nil); err == nil {
+		t.Error("loaded a server configuration without a port and a JWT secret")
+	}
+
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("MIGRATION_TIMEOUT", "-1m")
+	_, err = LoadDatabase(nil)
+	want := "invalid configuration:\ndatabase_url: is required\nmigration_timeout: must be positive, got -1m0s"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting describes how a single configuration value is read from the
+// environment and the command line.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// settings lists every configuration value that can be overridden by an
+// environment variable or a command-line flag.
+var settings = []setting{
+	{"PORT", "port", "port the HTTP server listens on", stringSetting(func(c *Config) *string { return &c.Port })},
+	{"DATABASE_URL", "database-url", "MongoDB connection string including the database name",
+		stringSetting(func(c *Config) *string { return &c.DatabaseURL })},
+	{"JWT_SECRET", "jwt-secret", "secret used to sign tokens with HS256",
+		stringSetting(func(c *Config) *string { return &c.JwtSecret })},
+	{"JWT_KEYS_DIR", "jwt-keys-dir", "directory of <kid>.pem signing and verification keys",
+		stringSetting(func(c *Config) *string { return &c.JwtKeysDir })},
+	{"JWT_SIGNING_KEY_ID", "jwt-signing-key-id", "ID of the key in the keys directory that signs new tokens",
+		stringSetting(func(c *Config) *string { return &c.JwtSigningKeyID })},
+	{"ACCESS_EXPIRATION", "access-expiration", "lifetime of access tokens, e.g. 1h",
+		durationSetting(func(c *Config) *time.Duration { return &c.AccessExpiration })},
+	{"REFRESH_EXPIRATION", "refresh-expiration", "lifetime of refresh tokens, e.g. 168h",
+		durationSetting(func(c *Config) *time.Duration { return &c.RefreshExpiration })},
+	{"ROTATE_REFRESH_TOKENS", "rotate-refresh-tokens", "replace refresh tokens each time they are used",
+		boolSetting(func(c *Config) *bool { return &c.RotateRefreshTokens })},
+	{"LEGACY_TOKEN_COMPATIBILITY", "legacy-token-compatibility", "accept tokens issued with the old claim names",
+		boolSetting(func(c *Config) *bool { return &c.LegacyTokenCompatibility })},
+	{"LEGACY_TOKEN_MAX_AGE", "legacy-token-max-age", "how long after they were issued old-format tokens are accepted",
+		durationSetting(func(c *Config) *time.Duration { return &c.LegacyTokenMaxAge })},
+	{"BCRYPT_COST", "bcrypt-cost", "bcrypt cost used to hash passwords",
+		intSetting(func(c *Config) *int { return &c.BcryptCost })},
+	{"CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma-separated list of allowed CORS origins",
+		listSetting(func(c *Config) *[]string { return &c.CORSAllowedOrigins })},
+	{"READ_TIMEOUT", "read-timeout", "maximum duration for reading a request",
+		durationSetting(func(c *Config) *time.Duration { return &c.ReadTimeout })},
+	{"READ_HEADER_TIMEOUT", "read-header-timeout", "maximum duration for reading request headers",
+		durationSetting(func(c *Config) *time.Duration { return &c.ReadHeaderTimeout })},
+	{"WRITE_TIMEOUT", "write-timeout", "maximum duration for writing a response, streams excluded",
+		durationSetting(func(c *Config) *time.Duration { return &c.WriteTimeout })},
+	{"IDLE_TIMEOUT", "idle-timeout", "maximum time to wait for the next request on a keep-alive connection",
+		durationSetting(func(c *Config) *time.Duration { return &c.IdleTimeout })},
+	{"DATABASE_CONNECT_TIMEOUT", "database-connect-timeout", "maximum time to wait for MongoDB at startup",
+		durationSetting(func(c *Config) *time.Duration { return &c.DatabaseConnectTimeout })},
+	{"DATABASE_TIMEOUT", "database-timeout", "maximum time each database operation may take",
+		durationSetting(func(c *Config) *time.Duration { return &c.DatabaseTimeout })},
+	{"DATABASE_OPERATION_TIMEOUTS", "database-operation-timeouts",
+		"comma-separated per-operation database timeouts, e.g. GetMessages=10s,CreateMessage=2s",
+		durationMapSetting(func(c *Config) *map[string]time.Duration { return &c.DatabaseOperationTimeouts })},
+	{"MIGRATE_ON_STARTUP", "migrate-on-startup", "apply pending schema migrations before serving",
+		boolSetting(func(c *Config) *bool { return &c.MigrateOnStartup })},
+	{"MIGRATION_TIMEOUT", "migration-timeout", "maximum time applying or reverting schema migrations may take",
+		durationSetting(func(c *Config) *time.Duration { return &c.MigrationTimeout })},
+	{"LOG_LEVEL", "log-level", "minimum level of the logs: debug, info, warn or error",
+		stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	{"TRACING_EXPORTER", "tracing-exporter", "where spans are exported: none, stdout or otlp",
+		stringSetting(func(c *Config) *string { return &c.TracingExporter })},
+	{"TRACING_OTLP_ENDPOINT", "tracing-otlp-endpoint", "host:port of the OTLP/HTTP trace collector",
+		stringSetting(func(c *Config) *string { return &c.TracingOTLPEndpoint })},
+	{"TRACING_OTLP_INSECURE", "tracing-otlp-insecure", "send spans to the collector over plain HTTP",
+		boolSetting(func(c *Config) *bool { return &c.TracingOTLPInsecure })},
+	{"HEALTH_CHECK_TIMEOUT", "health-check-timeout", "maximum time each readiness dependency check may take",
+		durationSetting(func(c *Config) *time.Duration { return &c.HealthCheckTimeout })},
+	{"SHUTDOWN_DELAY", "shutdown-delay", "time to keep serving with readiness failing before shutting down",
+		durationSetting(func(c *Config) *time.Duration { return &c.ShutdownDelay })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "maximum time to drain requests and streams on shutdown",
+		durationSetting(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+}
+
+// Load builds the configuration from the defaults, the configuration file, the
+// environment and the command-line arguments, and validates the result. The
+// configuration file is named by the -config flag or the CONFIG_FILE variable.
+func Load(args []string) (Config, error) {
+	return load(args, Config.Validate)
+}
+
+// LoadDatabase builds the configuration like Load but only validates the database
+// settings, for commands such as migrate that do not run the server.
+func LoadDatabase(args []string) (Config, error) {
+	return load(args, Config.ValidateDatabase)
+}
+
+// load builds the configuration and checks the result with validate.
+func load(args []string, validate func(Config) error) (Config, error) {
+	// Register a flag for every setting plus the configuration file
+	fs := flag.NewFlagSet("go-chat-application", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML or TOML configuration file")
+	values := make(map[string]*string)
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+
+	// Overlay the configuration file
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// Overlay the environment variables
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	// Overlay the flags that were given on the command line
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *values[f.Name]); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	// Check the result before anything uses it
+	if err := validate(cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadFile decodes the YAML or TOML file at path into cfg, keeping the current
+// value of any setting the file does not mention.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading configuration file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return fmt.Errorf("parsing configuration file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing configuration file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing configuration file %s: unknown setting %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("configuration file %s: unsupported extension, use .yaml, .yml or .toml", path)
+	}
+
+	return nil
+}
+
+func stringSetting(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolSetting(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func intSetting(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func listSetting(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func durationMapSetting(field func(*Config) *map[string]time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		m := map[string]time.Duration{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, raw, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q, want name=duration", item)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(raw))
+			if err != nil {
+				return fmt.Errorf("invalid duration %q for %s", raw, key)
+			}
+			m[strings.TrimSpace(key)] = d
+		}
+		*field(c) = m
+		return nil
+	}
+}
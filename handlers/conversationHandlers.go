@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go-chat-application/internal/database"
+	"go-chat-application/realtime"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// conversationToMap converts a conversation into its JSON representation
+func conversationToMap(conversation database.Conversation) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":        conversation.ID,
+		"name":       conversation.Name,
+		"users":      conversation.Users,
+		"created_at": conversation.CreatedAt,
+		"updated_at": conversation.UpdatedAt,
+	}
+}
+
+// publishToConversation pushes a real-time event to every member of the conversation
+// and to any extra users, such as a member who has just been removed
+func (s *Server) publishToConversation(ctx context.Context, conversation database.Conversation, eventType string,
+	data interface{}, extraUsers ...primitive.ObjectID) {
+	s.publishEvent(ctx, conversation, realtime.Event{Type: eventType, Data: data}, extraUsers...)
+}
+
+// publishEvent pushes the event to every member of the conversation and to any extra users
+func (s *Server) publishEvent(ctx context.Context, conversation database.Conversation, event realtime.Event,
+	extraUsers ...primitive.ObjectID) {
+	userIDs := []string{}
+	for _, id := range conversation.Users {
+		userIDs = append(userIDs, id.Hex())
+	}
+	for _, id := range extraUsers {
+		userIDs = append(userIDs, id.Hex())
+	}
+
+	event.ConversationID = conversation.ID.Hex()
+	s.Hub.Publish(ctx, userIDs, event)
+}
+
+// getConversationForMember loads the conversation named in the URL and checks that the
+// caller is one of its members. It writes an error response and returns false otherwise.
+func (s *Server) getConversationForMember(w http.ResponseWriter, r *http.Request,
+	userID primitive.ObjectID) (database.Conversation, bool) {
+	// Get the conversation from the database
+	conversation, err := s.Conversations.GetConversation(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, database.ErrConversationNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		return database.Conversation{}, false
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get conversation", err)
+		return database.Conversation{}, false
+	}
+
+	// Only members of the conversation are allowed to access it
+	if !conversation.HasUser(userID) {
+		RespondWithError(w, r, http.StatusForbidden, "You are not a member of this conversation")
+		return database.Conversation{}, false
+	}
+
+	return conversation, true
+}
+
+// CreateConversationHandler creates a new conversation with the caller as a member
+func (s *Server) CreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Define the parameters structure
+	var params struct {
+		Name  string   `json:"name"`
+		Users []string `json:"users"`
+	}
+
+	// Decode the request body into the parameters structure
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// A conversation must have a name
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		RespondWithError(w, r, http.StatusBadRequest, "Conversation name is required")
+		return
+	}
+
+	// Build the member list, always including the caller and skipping duplicates
+	users := []primitive.ObjectID{userID}
+	seen := map[primitive.ObjectID]bool{userID: true}
+	for _, id := range params.Users {
+		memberID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			RespondWithError(w, r, http.StatusBadRequest, "Invalid user ID: "+id)
+			return
+		}
+		if !seen[memberID] {
+			seen[memberID] = true
+			users = append(users, memberID)
+		}
+	}
+
+	// Create the conversation in the database
+	conversation, err := s.Conversations.CreateConversation(r.Context(), params.Name, users)
+	if errors.Is(err, database.ErrUnknownUsers) {
+		RespondWithError(w, r, http.StatusBadRequest, "One or more users do not exist")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to create conversation", err)
+		return
+	}
+
+	// Notify the members and respond with the created conversation
+	s.publishToConversation(r.Context(), conversation, realtime.EventConversationCreated, conversationToMap(conversation))
+	RespondWithJSON(w, http.StatusCreated, conversationToMap(conversation))
+}
+
+// GetConversationsHandler retrieves every conversation the caller is a member of
+func (s *Server) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Get the caller's conversations from the database
+	conversations, err := s.Conversations.GetConversationsForUser(r.Context(), userID)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get conversations", err)
+		return
+	}
+
+	// Convert the conversations into their JSON representation
+	conversationMap := []map[string]interface{}{}
+	for _, conversation := range conversations {
+		conversationMap = append(conversationMap, conversationToMap(conversation))
+	}
+
+	// Respond with the conversations
+	RespondWithJSON(w, http.StatusOK, conversationMap)
+}
+
+// GetConversationHandler retrieves a single conversation the caller is a member of
+func (s *Server) GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Respond with the conversation
+	RespondWithJSON(w, http.StatusOK, conversationToMap(conversation))
+}
+
+// RenameConversationHandler changes the name of a conversation the caller is a member of
+func (s *Server) RenameConversationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Define the parameters structure
+	var params struct {
+		Name string `json:"name"`
+	}
+
+	// Decode the request body into the parameters structure
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// A conversation must have a name
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		RespondWithError(w, r, http.StatusBadRequest, "Conversation name is required")
+		return
+	}
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Rename the conversation in the database
+	conversation, err := s.Conversations.RenameConversation(r.Context(), conversation.ID.Hex(), params.Name)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to rename conversation", err)
+		return
+	}
+
+	// Notify the members and respond with the updated conversation
+	s.publishToConversation(r.Context(), conversation, realtime.EventConversationUpdated, conversationToMap(conversation))
+	RespondWithJSON(w, http.StatusOK, conversationToMap(conversation))
+}
+
+// DeleteConversationHandler deletes a conversation the caller is a member of
+func (s *Server) DeleteConversationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Delete the conversation from the database
+	err := s.Conversations.DeleteConversation(r.Context(), conversation.ID.Hex())
+	if errors.Is(err, database.ErrConversationNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to delete conversation", err)
+		return
+	}
+
+	// Notify the members and respond with a success message
+	s.publishToConversation(r.Context(), conversation, realtime.EventConversationDeleted, conversationToMap(conversation))
+	RespondWithJSON(w, http.StatusOK, "Conversation deleted successfully")
+}
+
+// AddConversationUserHandler adds a user to a conversation the caller is a member of
+func (s *Server) AddConversationUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Define the parameters structure
+	var params struct {
+		UserID string `json:"user_id"`
+	}
+
+	// Decode the request body into the parameters structure
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// Convert the user ID to a MongoDB ObjectID
+	memberID, err := primitive.ObjectIDFromHex(params.UserID)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid user ID: "+params.UserID)
+		return
+	}
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Add the user to the conversation in the database
+	conversation, err = s.Conversations.AddConversationUser(r.Context(), conversation.ID.Hex(), memberID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, database.ErrConversationNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to add user", err)
+		return
+	}
+
+	// Notify the members and respond with the updated conversation
+	s.publishToConversation(r.Context(), conversation, realtime.EventMemberAdded, map[string]interface{}{
+		"user_id":      memberID,
+		"conversation": conversationToMap(conversation),
+	})
+	RespondWithJSON(w, http.StatusOK, conversationToMap(conversation))
+}
+
+// RemoveConversationUserHandler removes a user from a conversation the caller is a member of.
+// Members may remove themselves to leave the conversation.
+func (s *Server) RemoveConversationUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Convert the user ID to a MongoDB ObjectID
+	memberID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userID"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid user ID: "+chi.URLParam(r, "userID"))
+		return
+	}
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// The user being removed must be a member
+	if !conversation.HasUser(memberID) {
+		RespondWithError(w, r, http.StatusNotFound, "User is not a member of this conversation")
+		return
+	}
+
+	// Remove the user from the conversation in the database
+	conversation, err = s.Conversations.RemoveConversationUser(r.Context(), conversation.ID.Hex(), memberID)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to remove user", err)
+		return
+	}
+
+	// Notify the remaining members and the removed user, and respond with the updated conversation
+	s.publishToConversation(r.Context(), conversation, realtime.EventMemberRemoved, map[string]interface{}{
+		"user_id":      memberID,
+		"conversation": conversationToMap(conversation),
+	}, memberID)
+	RespondWithJSON(w, http.StatusOK, conversationToMap(conversation))
+}
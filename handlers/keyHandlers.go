@@ -0,0 +1,11 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// JWKSHandler publishes the public keys that tokens issued by this server can be verified with
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	RespondWithJSON(w, http.StatusOK, s.Tokens.Keys.JWKS())
+}
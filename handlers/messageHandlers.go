@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go-chat-application/internal/database"
+	"go-chat-application/realtime"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Define limits for message content and history pages
+const MaxMessageLength = 4000
+const DefaultMessagePageSize = 50
+const MaxMessagePageSize = 100
+
+// messageToMap converts a message into its JSON representation
+func messageToMap(message database.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"_id":             message.ID,
+		"conversation_id": message.ConversationID,
+		"sender_id":       message.SenderID,
+		"content":         message.Content,
+		"created_at":      message.CreatedAt,
+		"deleted":         !message.DeletedAt.IsZero(),
+	}
+}
+
+// messageEvent builds the real-time event announcing a new message. The message ID
+// doubles as the event ID so that clients can resume from it.
+func messageEvent(message database.Message) realtime.Event {
+	return realtime.Event{
+		ID:             message.ID.Hex(),
+		Type:           realtime.EventMessageCreated,
+		ConversationID: message.ConversationID.Hex(),
+		Data:           messageToMap(message),
+	}
+}
+
+// SendMessageHandler stores a new message in a conversation the caller is a member of
+func (s *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Define the parameters structure
+	var params struct {
+		Content string `json:"content"`
+	}
+
+	// Decode the request body into the parameters structure
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	// Validate the message content
+	if strings.TrimSpace(params.Content) == "" {
+		RespondWithError(w, r, http.StatusBadRequest, "Message content is required")
+		return
+	}
+	if utf8.RuneCountInString(params.Content) > MaxMessageLength {
+		RespondWithError(w, r, http.StatusBadRequest,
+			"Message content must be at most "+strconv.Itoa(MaxMessageLength)+" characters")
+		return
+	}
+
+	// Load the conversation and check that the sender is a member
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Store the message in the database
+	message, err := s.Messages.CreateMessage(r.Context(), conversation.ID, userID, params.Content)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		// The conversation was deleted or the sender removed from it in the meantime,
+		// so check again to respond with the right status
+		if _, ok := s.getConversationForMember(w, r, userID); ok {
+			RespondWithError(w, r, http.StatusNotFound, "Conversation not found")
+		}
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to send message", err)
+		return
+	}
+
+	// Push the message to the members and respond with the created message
+	s.Metrics.MessageSent()
+	s.publishEvent(r.Context(), conversation, messageEvent(message))
+	RespondWithJSON(w, http.StatusCreated, messageToMap(message))
+}
+
+// GetMessagesHandler retrieves a page of the message history of a conversation the caller is a member of
+func (s *Server) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Parse the pagination parameters from the query string
+	page, err := parseMessagePage(r)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Load the conversation and check membership
+	conversation, ok := s.getConversationForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	// Get the messages from the database
+	messages, hasMore, err := s.Messages.GetMessages(r.Context(), conversation.ID, page)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get messages", err)
+		return
+	}
+
+	// Convert the messages into their JSON representation
+	messageMap := []map[string]interface{}{}
+	for _, message := range messages {
+		messageMap = append(messageMap, messageToMap(message))
+	}
+
+	// Respond with the messages and whether another page exists
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"messages": messageMap,
+		"has_more": hasMore,
+	})
+}
+
+// parseMessagePage reads the before, after and limit query parameters
+func parseMessagePage(r *http.Request) (database.MessagePage, error) {
+	query := r.URL.Query()
+	page := database.MessagePage{Limit: DefaultMessagePageSize}
+
+	// Only one cursor direction may be used at a time
+	if query.Get("before") != "" && query.Get("after") != "" {
+		return page, errors.New("only one of before and after may be set")
+	}
+
+	// Parse the cursors
+	if before := query.Get("before"); before != "" {
+		id, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return page, errors.New("invalid before cursor")
+		}
+		page.Before = id
+	}
+	if after := query.Get("after"); after != "" {
+		id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return page, errors.New("invalid after cursor")
+		}
+		page.After = id
+	}
+
+	// Parse the page size, capping it at the maximum
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 {
+			return page, errors.New("limit must be a positive number")
+		}
+		page.Limit = min(n, MaxMessagePageSize)
+	}
+
+	return page, nil
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	// Embed the time zone database so that time zones can be checked on hosts without one
+	_ "time/tzdata"
+
+	"go-chat-application/internal/database"
+
+	"golang.org/x/text/language"
+)
+
+// Define limits for the profile fields
+const MaxDisplayNameLength = 64
+const MaxAvatarURLLength = 2048
+const MaxBioLength = 500
+const MaxStatusTextLength = 100
+const MaxStatusEmojiLength = 16
+
+// avatarImageIDPattern matches the IDs of uploaded images
+var avatarImageIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// profileToMap adds the profile fields of the user to its JSON representation.
+// Empty fields and expired statuses are left out.
+func profileToMap(user database.User, userMap map[string]interface{}) map[string]interface{} {
+	fields := map[string]string{
+		"display_name":    user.DisplayName,
+		"avatar_url":      user.AvatarURL,
+		"avatar_image_id": user.AvatarImageID,
+		"bio":             user.Bio,
+		"timezone":        user.Timezone,
+		"locale":          user.Locale,
+	}
+	for field, value := range fields {
+		if value != "" {
+			userMap[field] = value
+		}
+	}
+
+	if user.Status != nil && !user.Status.Expired(time.Now()) {
+		status := map[string]interface{}{}
+		if user.Status.Text != "" {
+			status["text"] = user.Status.Text
+		}
+		if user.Status.Emoji != "" {
+			status["emoji"] = user.Status.Emoji
+		}
+		if !user.Status.ExpiresAt.IsZero() {
+			status["expires_at"] = user.Status.ExpiresAt
+		}
+		userMap["status"] = status
+	}
+
+	return userMap
+}
+
+// profileParams holds the profile fields of an update request. Missing fields are
+// left as they are and empty ones are cleared.
+type profileParams struct {
+	DisplayName   *string
+	AvatarURL     *string
+	AvatarImageID *string
+	Bio           *string
+	Status        *statusParams
+	Timezone      *string
+	Locale        *string
+}
+
+// statusParams holds the custom status fields of an update request. Missing fields
+// are left as they are, and empty ones, including a zero expiry, are cleared.
+type statusParams struct {
+	Text      *string
+	Emoji     *string
+	ExpiresAt *time.Time
+}
+
+// invalidFieldsResponse is the body of a response rejecting some fields of a request
+type invalidFieldsResponse struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
+}
+
+// validate checks and normalizes the given fields. It returns the resulting update
+// and, for each invalid field, what is wrong with it.
+func (p profileParams) validate(now time.Time) (database.ProfileUpdate, map[string]string) {
+	update := database.ProfileUpdate{}
+	invalid := map[string]string{}
+
+	if p.DisplayName != nil {
+		name := strings.TrimSpace(*p.DisplayName)
+		if err := checkText(name, MaxDisplayNameLength, false); err != nil {
+			invalid["display_name"] = err.Error()
+		}
+		update.DisplayName = &name
+	}
+
+	// An avatar is either a URL or an uploaded image, so setting one clears the other
+	if p.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*p.AvatarURL)
+		if err := checkAvatarURL(avatarURL); err != nil {
+			invalid["avatar_url"] = err.Error()
+		}
+		update.AvatarURL = &avatarURL
+	}
+	if p.AvatarImageID != nil {
+		imageID := strings.TrimSpace(*p.AvatarImageID)
+		if imageID != "" && !avatarImageIDPattern.MatchString(imageID) {
+			invalid["avatar_image_id"] = "must be an image ID of letters, digits, - and _"
+		}
+		update.AvatarImageID = &imageID
+	}
+	switch {
+	case update.AvatarURL != nil && *update.AvatarURL != "" && update.AvatarImageID != nil && *update.AvatarImageID != "":
+		invalid["avatar_image_id"] = "cannot be set together with avatar_url"
+	case update.AvatarURL != nil && *update.AvatarURL != "":
+		update.AvatarImageID = new(string)
+	case update.AvatarImageID != nil && *update.AvatarImageID != "":
+		update.AvatarURL = new(string)
+	}
+
+	if p.Bio != nil {
+		bio := strings.TrimSpace(*p.Bio)
+		if err := checkText(bio, MaxBioLength, true); err != nil {
+			invalid["bio"] = err.Error()
+		}
+		update.Bio = &bio
+	}
+
+	// The status is changed field by field. A status left without a text and an
+	// emoji is cleared by the update, whatever its expiry.
+	if p.Status != nil {
+		status := database.StatusUpdate{}
+		if p.Status.Text != nil {
+			text := strings.TrimSpace(*p.Status.Text)
+			if err := checkText(text, MaxStatusTextLength, false); err != nil {
+				invalid["status.text"] = err.Error()
+			}
+			status.Text = &text
+		}
+		if p.Status.Emoji != nil {
+			emoji := strings.TrimSpace(*p.Status.Emoji)
+			if emoji != "" && !isEmoji(emoji) {
+				invalid["status.emoji"] = "must be an emoji"
+			}
+			status.Emoji = &emoji
+		}
+		if p.Status.ExpiresAt != nil {
+			expiresAt := p.Status.ExpiresAt.UTC()
+			if !expiresAt.IsZero() && !expiresAt.After(now) {
+				invalid["status.expires_at"] = "must be in the future"
+			}
+			status.ExpiresAt = &expiresAt
+		}
+		update.Status = &status
+	}
+
+	if p.Timezone != nil {
+		timezone := strings.TrimSpace(*p.Timezone)
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+				invalid["timezone"] = "must be an IANA time zone such as Europe/Paris"
+			}
+		}
+		update.Timezone = &timezone
+	}
+
+	// Store locales in their canonical form, e.g. "en-US"
+	if p.Locale != nil {
+		locale := strings.TrimSpace(*p.Locale)
+		if locale != "" {
+			if tag, err := language.Parse(locale); err != nil {
+				invalid["locale"] = "must be a BCP 47 language tag such as en-US"
+			} else {
+				locale = tag.String()
+			}
+		}
+		update.Locale = &locale
+	}
+
+	return update, invalid
+}
+
+// checkText checks the length of a text field and that it has no control
+// characters. Line breaks are only allowed in multiline fields.
+func checkText(text string, maxLength int, multiline bool) error {
+	if utf8.RuneCountInString(text) > maxLength {
+		return errors.New("must be at most " + strconv.Itoa(maxLength) + " characters")
+	}
+	for _, c := range text {
+		if unicode.IsControl(c) && !(multiline && c == '\n') {
+			return errors.New("must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// checkAvatarURL checks that an avatar URL is an absolute HTTPS URL
+func checkAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > MaxAvatarURLLength {
+		return errors.New("must be at most " + strconv.Itoa(MaxAvatarURLLength) + " characters")
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("must be an absolute https URL")
+	}
+	return nil
+}
+
+// isEmoji reports whether text is made of emoji characters only, including the
+// joiners, variation selectors and modifiers that combine them into one emoji
+func isEmoji(text string) bool {
+	if utf8.RuneCountInString(text) > MaxStatusEmojiLength {
+		return false
+	}
+	for _, c := range text {
+		switch {
+		case unicode.In(c, unicode.So, unicode.Sk, unicode.Me):
+		case c == '\u200d', c == '\ufe0f', c >= 0xe0020 && c <= 0xe007f:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,66 @@
 package handlers
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"time"
+)
 
-func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+// HealthCheck reports whether one dependency of the server is usable.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// dependencyStatus is the result of a single health check.
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// HealthzHandler reports that the process is alive. It never checks dependencies,
+// so an unreachable database does not get the process restarted.
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func ErrorHandler(w http.ResponseWriter, r *http.Request) {
-	RespondWithError(w, http.StatusInternalServerError, "Internal Server Error")
+// ReadinessHandler reports whether the server can serve traffic. Every dependency is
+// checked with a timeout, and the server is never ready while it is shutting down.
+// Check errors are logged rather than returned, as the endpoint is public.
+func (s *Server) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	// Check every dependency, recording its status and latency
+	ready := true
+	checks := map[string]dependencyStatus{}
+	for _, check := range s.HealthChecks {
+		ctx, cancel := context.WithTimeout(r.Context(), s.Config.HealthCheckTimeout)
+		start := time.Now()
+		err := check.Check(ctx)
+		cancel()
+
+		result := dependencyStatus{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			ready = false
+			result.Status = "unavailable"
+			s.Logger.Warn("Readiness check failed", "dependency", check.Name, "error", err)
+		}
+		checks[check.Name] = result
+	}
+
+	// Pick the overall status, failing while the server drains
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	if s.ShuttingDown() {
+		status, code = "shutting_down", http.StatusServiceUnavailable
+	}
+
+	RespondWithJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func (s *Server) ErrorHandler(w http.ResponseWriter, r *http.Request) {
+	RespondWithError(w, r, http.StatusInternalServerError, "Internal Server Error")
 }
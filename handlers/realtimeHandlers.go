@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go-chat-application/auth"
+	"go-chat-application/metrics"
+	"go-chat-application/realtime"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxReplayedEvents is the maximum number of missed messages replayed when an
+// event stream resumes from a Last-Event-ID. A client that missed more receives a
+// resync event instead and must fetch the history again.
+const MaxReplayedEvents = 500
+
+// WebSocketHandler upgrades an authenticated request to a WebSocket that receives
+// live events for every conversation the caller is a member of
+func (s *Server) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	// Live events need a hub to come from
+	if s.Hub == nil {
+		RespondWithError(w, r, http.StatusServiceUnavailable, "Live events are not available")
+		return
+	}
+
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+
+	// Stream events until the client disconnects
+	defer s.Metrics.ConnectionOpened(metrics.TransportWebSocket)()
+	realtime.ServeWS(s.Hub, w, r, principal.UserID.Hex(), s.Config.CORSAllowedOrigins, tokenExpiry(principal))
+}
+
+// EventStreamHandler streams the same live events as WebSocketHandler using
+// Server-Sent Events. If the client sends a Last-Event-ID header, messages it
+// missed since that event are replayed before the live stream starts.
+//
+// Event IDs are message ObjectIDs, which begin with the second they were created
+// in followed by a per-process counter. Across server processes their order only
+// roughly follows creation time, so a message created within the same second as
+// the Last-Event-ID by another process may not be replayed.
+func (s *Server) EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	// Live events need a hub to come from
+	if s.Hub == nil {
+		RespondWithError(w, r, http.StatusServiceUnavailable, "Live events are not available")
+		return
+	}
+
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+	userID := principal.UserID
+
+	// Parse the ID of the last event the client received, if any
+	var lastEventID primitive.ObjectID
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := primitive.ObjectIDFromHex(header)
+		if err != nil {
+			RespondWithError(w, r, http.StatusBadRequest, "Invalid Last-Event-ID header")
+			return
+		}
+		lastEventID = id
+	}
+
+	// Subscribe before loading missed messages so that nothing falls in between
+	subscriber := s.Hub.Subscribe(userID.Hex())
+
+	// Load the messages the client missed from the caller's conversations
+	replay := []realtime.Event{}
+	if !lastEventID.IsZero() {
+		conversations, err := s.Conversations.GetConversationsForUser(r.Context(), userID)
+		if err != nil {
+			s.Hub.Unsubscribe(subscriber)
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get conversations", err)
+			return
+		}
+
+		conversationIDs := []primitive.ObjectID{}
+		for _, conversation := range conversations {
+			conversationIDs = append(conversationIDs, conversation.ID)
+		}
+
+		// Fetch one extra message to find out whether the client missed too many
+		messages, err := s.Messages.GetMessagesSince(r.Context(), conversationIDs, lastEventID, MaxReplayedEvents+1)
+		if err != nil {
+			s.Hub.Unsubscribe(subscriber)
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get messages", err)
+			return
+		}
+
+		// Rather than replay part of what was missed, tell the client to fetch the history again
+		if len(messages) > MaxReplayedEvents {
+			replay = append(replay, realtime.Event{
+				Type: realtime.EventResync,
+				Data: map[string]interface{}{"reason": "too many missed events"},
+			})
+		} else {
+			for _, message := range messages {
+				replay = append(replay, messageEvent(message))
+			}
+		}
+	}
+
+	// Stream events until the client disconnects
+	defer s.Metrics.ConnectionOpened(metrics.TransportSSE)()
+	realtime.ServeSSE(s.Hub, w, r, subscriber, replay, tokenExpiry(principal))
+}
+
+// tokenExpiry returns when the access token of the caller expires. Streams are only
+// authenticated when they open, so they are closed at that time.
+func tokenExpiry(principal *auth.Principal) time.Time {
+	if principal.Claims == nil || principal.Claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return principal.Claims.ExpiresAt.Time
+}
@@ -1,16 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+
+	"go-chat-application/internal/database"
+	"go-chat-application/logging"
 )
 
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	data, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %v", err)
+		slog.Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -18,9 +23,32 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(data)
 }
 
-func RespondWithError(w http.ResponseWriter, code int, msg string) {
+// RespondWithError responds with code and an error message. 5XX errors are logged
+// with the logger of the request.
+func RespondWithError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	respondWithError(w, r, code, msg, nil)
+}
+
+// RespondWithDatabaseError responds with 504 when err was caused by a database
+// or token store operation running out of time, and with code and msg otherwise.
+// err is logged along with 5XX errors but never sent to the client.
+func RespondWithDatabaseError(w http.ResponseWriter, r *http.Request, code int, msg string, err error) {
+	if errors.Is(err, database.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		respondWithError(w, r, http.StatusGatewayTimeout, "Database operation timed out", err)
+		return
+	}
+	respondWithError(w, r, code, msg, err)
+}
+
+// respondWithError responds with code and msg, logging 5XX errors and their cause.
+func respondWithError(w http.ResponseWriter, r *http.Request, code int, msg string, err error) {
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		logger := logging.FromContext(r.Context())
+		if err != nil {
+			logger.Error("Responding with 5XX error", "status", code, "message", msg, "error", err)
+		} else {
+			logger.Error("Responding with 5XX error", "status", code, "error", msg)
+		}
 	}
 
 	type errorResponse struct {
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"go-chat-application/config"
+	"go-chat-application/internal/database"
+	"go-chat-application/metrics"
+	"go-chat-application/realtime"
+	"go-chat-application/tokenPackage"
+)
+
+// Server owns the dependencies of one application instance. Every HTTP handler
+// is a method on it, so several isolated instances can run in the same process.
+type Server struct {
+	Config        config.Config
+	Users         database.UserRepository
+	Conversations database.ConversationRepository
+	Messages      database.MessageRepository
+	Tokens        *tokenPackage.TokenService
+	Hub           *realtime.Hub
+	Logger        *slog.Logger
+	Metrics       *metrics.Metrics
+
+	// HealthChecks are the dependencies reported by the readiness endpoint
+	HealthChecks []HealthCheck
+
+	shuttingDown atomic.Bool
+}
+
+// NewServer creates a server that stores its data in the given repository. A nil
+// logger is replaced with slog.Default().
+func NewServer(cfg config.Config, db database.Repository, tokens *tokenPackage.TokenService,
+	hub *realtime.Hub, logger *slog.Logger, m *metrics.Metrics) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Server{
+		Config:        cfg,
+		Users:         db,
+		Conversations: db,
+		Messages:      db,
+		Tokens:        tokens,
+		Hub:           hub,
+		Logger:        logger,
+		Metrics:       m,
+		HealthChecks:  []HealthCheck{{Name: "database", Check: db.CheckHealth}},
+	}
+}
+
+// BeginShutdown makes the readiness endpoint fail so that no new traffic is
+// routed to the server while it drains.
+func (s *Server) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether BeginShutdown has been called.
+func (s *Server) ShuttingDown() bool {
+	return s.shuttingDown.Load()
+}
@@ -1,31 +1,49 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"net/mail"
+	"strconv"
 	"strings"
 	"time"
 
-	"go-chat-application/config"
+	"go-chat-application/auth"
 	"go-chat-application/internal/database"
+	"go-chat-application/logging"
 	"go-chat-application/tokenPackage"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Define constants for access and refresh token expiration times
-const AccessExpiration time.Duration = time.Hour
-const RefreshExpiration time.Duration = 7 * (time.Hour * 24)
+// userToMap converts a user into the JSON representation of their own account
+func userToMap(user database.User) map[string]interface{} {
+	return profileToMap(user, map[string]interface{}{
+		"_id":        user.ID,
+		"name":       user.Name,
+		"email":      user.Email,
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
+	})
+}
 
-// CreateUserHandler is a HTTP handler function that creates a new user
-func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the MongoDB client from the request context
-	ctx := r.Context()
-	client, _ := ctx.Value(config.ApiCfg.DB).(*database.MongoDBClient)
+// publicUserToMap converts a user into the JSON representation shown to other users
+func publicUserToMap(user database.User) map[string]interface{} {
+	return profileToMap(user, map[string]interface{}{
+		"_id":        user.ID,
+		"name":       user.Name,
+		"created_at": user.CreatedAt,
+	})
+}
 
+// CreateUserHandler is a HTTP handler function that creates a new user
+func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Define a struct to hold the request parameters
 	var params struct {
 		Name     string `json:"name"`
@@ -36,206 +54,464 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Decode the request body into the params struct
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&params); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	// Create a new user using the provided parameters
-	user, err := client.CreateUser(params.Name, params.Email, params.Password)
+	// Hash the password using bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), s.Config.BcryptCost)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to create user")
+		RespondWithError(w, r, http.StatusInternalServerError, "Unable to hash password")
 		return
 	}
 
-	// Create a map to hold the user data
-	userMap := map[string]interface{}{
-		"_id":        user.ID,
-		"name":       user.Name,
-		"email":      user.Email,
-		"created_at": user.CreatedAt,
-		"updated_at": user.UpdatedAt,
+	// Create a new user using the provided parameters
+	user, err := s.Users.CreateUser(r.Context(), params.Name, params.Email, string(hashedPassword))
+	if errors.Is(err, database.ErrEmailInUse) {
+		RespondWithError(w, r, http.StatusConflict, "Email already in use")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to create user", err)
+		return
 	}
 
 	// Respond with the created user data
-	RespondWithJSON(w, http.StatusCreated, userMap)
+	RespondWithJSON(w, http.StatusCreated, userToMap(user))
 }
 
-// GetUsersHandler is a HTTP handler function that retrieves all users
-func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the MongoDB client from the request context
-	ctx := r.Context()
-	client, _ := ctx.Value(config.ApiCfg.DB).(*database.MongoDBClient)
+// Define limits for user directory pages
+const DefaultUserPageSize = 50
+const MaxUserPageSize = 100
 
-	// Retrieve all users
-	users, err := client.GetAllUsers()
+// TotalCountHeader holds the number of users matching the search across all pages
+const TotalCountHeader = "X-Total-Count"
+
+// GetUsersHandler is a HTTP handler function that retrieves a page of the user directory.
+// The next page, if any, is linked in the Link header.
+func (s *Server) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the search, sort and pagination parameters from the query string
+	page, err := parseUserPage(r)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to get users")
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Retrieve the page of users
+	users, hasMore, total, err := s.Users.ListUsers(r.Context(), page)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get users", err)
 		return
 	}
 
 	// Create a slice of maps to hold the user data
-	var userMap []map[string]interface{}
+	userMap := []map[string]interface{}{}
 
-	// Loop over the users and add their data to the userMap slice
+	// Loop over the users and add their public data to the userMap slice
 	for _, user := range users {
-		userMap = append(userMap,
-			map[string]interface{}{
-				"_id":        user.ID,
-				"name":       user.Name,
-				"email":      user.Email,
-				"created_at": user.CreatedAt,
-				"updated_at": user.UpdatedAt,
-			},
-		)
+		userMap = append(userMap, publicUserToMap(user))
+	}
+
+	// Report the total and link the next page, which starts after the last user
+	w.Header().Set(TotalCountHeader, strconv.FormatInt(total, 10))
+	if hasMore {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", encodeUserCursor(page, users[len(users)-1]))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
 	}
 
 	// Respond with the user data
 	RespondWithJSON(w, http.StatusOK, userMap)
 }
 
-// UpdateUserHandler handles the user update request
-func UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the token and the database client from the request
-	token, _, client := ExtractDBAndToken(r)
+// userCursor is the content of the opaque cursor of the user directory. It holds
+// the sort order it was made for and the sort key of the last user of a page.
+type userCursor struct {
+	Sort       string             `json:"s"`
+	Descending bool               `json:"d,omitempty"`
+	Name       string             `json:"n,omitempty"`
+	CreatedAt  time.Time          `json:"c"`
+	ID         primitive.ObjectID `json:"i"`
+}
 
-	// If the token is nil, return immediately
-	if token == nil {
-		return
+// encodeUserCursor returns the cursor of the page following the given user
+func encodeUserCursor(page database.UserPage, last database.User) string {
+	data, _ := json.Marshal(userCursor{
+		Sort:       page.Sort,
+		Descending: page.Descending,
+		Name:       last.Name,
+		CreatedAt:  last.CreatedAt,
+		ID:         last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseUserPage reads the q, sort, cursor and limit query parameters
+func parseUserPage(r *http.Request) (database.UserPage, error) {
+	query := r.URL.Query()
+	page := database.UserPage{
+		Search: strings.TrimSpace(query.Get("q")),
+		Sort:   database.UserSortName,
+		Limit:  DefaultUserPageSize,
 	}
 
-	// Get the issuer from the token claims
-	issuer := token.Claims.(jwt.MapClaims)["Issuer"].(string)
+	// Parse the sort order; a leading "-" sorts in descending order
+	if sort := query.Get("sort"); sort != "" {
+		page.Descending = strings.HasPrefix(sort, "-")
+		page.Sort = strings.TrimPrefix(sort, "-")
+		if page.Sort != database.UserSortName && page.Sort != database.UserSortCreatedAt {
+			return page, errors.New("sort must be name, -name, created_at or -created_at")
+		}
+	}
+
+	// Parse the cursor, which is only valid for the sort order it was made for
+	if cursor := query.Get("cursor"); cursor != "" {
+		var after userCursor
+		data, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || json.Unmarshal(data, &after) != nil || after.ID.IsZero() {
+			return page, errors.New("invalid cursor")
+		}
+		if after.Sort != page.Sort || after.Descending != page.Descending {
+			return page, errors.New("cursor does not match the sort order")
+		}
+		page.After = &database.User{ID: after.ID, Name: after.Name, CreatedAt: after.CreatedAt}
+	}
+
+	// Parse the page size
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 || n > MaxUserPageSize {
+			return page, errors.New("limit must be between 1 and " + strconv.Itoa(MaxUserPageSize))
+		}
+		page.Limit = n
+	}
+
+	return page, nil
+}
 
-	// If the issuer is a refresh token, respond with an error
-	if issuer == "go-chat-application-refresh" {
-		RespondWithError(w, http.StatusUnauthorized,
-			"Using JWT refresh token when JWT access token is required")
+// GetCurrentUserHandler responds with the full profile of the caller
+func (s *Server) GetCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// The authentication middleware has already loaded the caller
+	principal := ExtractPrincipal(r)
+
+	RespondWithJSON(w, http.StatusOK, userToMap(principal.User))
+}
+
+// GetUserHandler responds with the public profile of the user with the given ID
+func (s *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the user ID from the URL
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// Get the user from the database
+	user, err := s.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get user", err)
 		return
 	}
 
+	RespondWithJSON(w, http.StatusOK, publicUserToMap(user))
+}
+
+// UpdateUserHandler handles the user update request. Empty fields are left as they
+// are, and the current password is required to change the email or the password.
+func (s *Server) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request
+	principal := ExtractPrincipal(r)
+
 	// Define the parameters structure
 	var params struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	// Decode the request body into the parameters structure
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	// Get all users from the database
-	users, err := client.GetAllUsers()
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to get users")
+	// Only change the fields that were given
+	update := database.UserUpdate{}
+	if params.Name != "" {
+		update.Name = &params.Name
+	}
+	if params.Email != "" && params.Email != principal.User.Email {
+		update.Email = &params.Email
+	}
+	if params.Password != "" {
+		update.Password = &params.Password
+	}
+
+	// Update the user in the database
+	if _, ok := s.updateUser(w, r, update, params.CurrentPassword); !ok {
 		return
 	}
 
-	// Get the user ID from the token claims
-	userID := token.Claims.(jwt.MapClaims)["Subject"].(string)
-	userID = strings.TrimPrefix(userID, "ObjectID(\"")
-	userID = strings.TrimSuffix(userID, "\")")
+	// Respond with a success message
+	RespondWithJSON(w, http.StatusOK, "User updated successfully")
+}
+
+// MergePatchContentType is the media type of JSON merge patches (RFC 7396)
+const MergePatchContentType = "application/merge-patch+json"
+
+// PatchCurrentUserHandler applies a JSON merge patch (RFC 7396) to the account and
+// profile of the caller: only the fields present in the patch change and null
+// removes a field. The current password is required to change the email or the
+// password. It responds with the updated user.
+func (s *Server) PatchCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Accept merge patches, and plain JSON from clients that do not name the media type
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != MergePatchContentType && mediaType != "application/json") {
+			w.Header().Set("Accept-Patch", MergePatchContentType)
+			RespondWithError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+MergePatchContentType)
+			return
+		}
+	}
 
-	// If the user ID is empty, respond with an error
-	if userID == "" {
-		RespondWithError(w, http.StatusUnauthorized, "Unable to get user ID from JWT token")
+	// A patch that is not an object would replace the whole user, which is not allowed
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload: the patch must be a JSON object")
 		return
 	}
 
-	// Convert the user ID to a MongoDB ObjectID
-	userPrimitiveID, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to convert user ID to primitive.ObjectID")
+	// Read and check every field and report all invalid ones at once
+	params, invalid := readUserPatch(patch)
+	profile, invalidProfile := params.profile.validate(time.Now())
+	for field, problem := range invalidProfile {
+		invalid[field] = problem
+	}
+	if len(invalid) > 0 {
+		RespondWithJSON(w, http.StatusBadRequest, invalidFieldsResponse{Error: "Invalid patch", Fields: invalid})
 		return
 	}
 
-	// Get the user name from the parameters or from the database
-	userName := params.Name
-	if userName == "" {
-		for _, user := range users {
-			if user.ID == userPrimitiveID {
-				userName = user.Name
-				break
-			}
-		}
+	// Update the user in the database, unless the patch changes nothing
+	update := database.UserUpdate{Name: params.name, Email: params.email, Password: params.password,
+		ProfileUpdate: profile}
+	user, ok := s.updateUser(w, r, update, params.currentPassword)
+	if !ok {
+		return
 	}
 
-	// Get the user email from the parameters or from the database
-	userEmail := params.Email
-	if userEmail == "" {
-		for _, user := range users {
-			if user.ID == userPrimitiveID {
-				userEmail = user.Email
-				break
+	// Respond with the updated user data
+	RespondWithJSON(w, http.StatusOK, userToMap(user))
+}
+
+// userPatch holds the fields read from a merge patch of a user
+type userPatch struct {
+	name, email, password *string
+	currentPassword       string
+	profile               profileParams
+}
+
+// readUserPatch reads the fields of a merge patch of a user. Profile fields set to
+// null are cleared, and the status is patched field by field. It also returns, for
+// each invalid field, what is wrong with it.
+func readUserPatch(patch map[string]json.RawMessage) (userPatch, map[string]string) {
+	params := userPatch{}
+	invalid := map[string]string{}
+
+	for field, value := range patch {
+		switch field {
+		// The account fields can be changed but not removed
+		case "name", "email", "password", "current_password":
+			text, err := decodePatchString(value)
+			if err != nil || text == nil || strings.TrimSpace(*text) == "" {
+				invalid[field] = "must be a non-empty string"
+				continue
+			}
+			switch field {
+			case "name":
+				name := strings.TrimSpace(*text)
+				params.name = &name
+			case "email":
+				email := strings.TrimSpace(*text)
+				if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+					invalid[field] = "must be an email address"
+				}
+				params.email = &email
+			case "password":
+				params.password = text
+			case "current_password":
+				params.currentPassword = *text
+			}
+
+		// The profile fields are cleared when set to null
+		case "display_name", "avatar_url", "avatar_image_id", "bio", "timezone", "locale":
+			text, err := decodePatchString(value)
+			if err != nil {
+				invalid[field] = "must be a string or null"
+				continue
+			}
+			if text == nil {
+				text = new(string)
+			}
+			switch field {
+			case "display_name":
+				params.profile.DisplayName = text
+			case "avatar_url":
+				params.profile.AvatarURL = text
+			case "avatar_image_id":
+				params.profile.AvatarImageID = text
+			case "bio":
+				params.profile.Bio = text
+			case "timezone":
+				params.profile.Timezone = text
+			case "locale":
+				params.profile.Locale = text
+			}
+
+		case "status":
+			status, problems := readStatusPatch(value)
+			for field, problem := range problems {
+				invalid[field] = problem
 			}
+			params.profile.Status = status
+
+		default:
+			invalid[field] = "unknown field"
 		}
 	}
 
-	// Get the user password from the parameters or from the database
-	var hashedPassword []byte
-	userPassword := params.Password
-	if userPassword == "" {
-		for _, user := range users {
-			if user.ID == userPrimitiveID {
-				hashedPassword = []byte(user.Password)
-				break
+	return params, invalid
+}
+
+// readStatusPatch reads the status fields of a merge patch. A null status clears
+// every field, and fields set to null are cleared.
+func readStatusPatch(value json.RawMessage) (*statusParams, map[string]string) {
+	status := &statusParams{}
+	invalid := map[string]string{}
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(value, &patch); err != nil {
+		invalid["status"] = "must be an object or null"
+		return status, invalid
+	}
+	if patch == nil {
+		status.Text, status.Emoji, status.ExpiresAt = new(string), new(string), new(time.Time)
+		return status, invalid
+	}
+
+	for field, value := range patch {
+		switch field {
+		case "text", "emoji":
+			text, err := decodePatchString(value)
+			if err != nil {
+				invalid["status."+field] = "must be a string or null"
+				continue
 			}
-		}
-	} else {
-		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, "Unable to hash password")
-			return
+			if text == nil {
+				text = new(string)
+			}
+			if field == "text" {
+				status.Text = text
+			} else {
+				status.Emoji = text
+			}
+		case "expires_at":
+			if err := json.Unmarshal(value, &status.ExpiresAt); err != nil {
+				invalid["status.expires_at"] = "must be an RFC 3339 time or null"
+				continue
+			}
+			if status.ExpiresAt == nil {
+				status.ExpiresAt = new(time.Time)
+			}
+		default:
+			invalid["status."+field] = "unknown field"
 		}
 	}
 
-	// Update the user in the database
-	if err := client.UpdateUser(userID, userName, userEmail, string(hashedPassword)); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to update user")
-		return
-	}
+	return status, invalid
+}
 
-	// Respond with a success message
-	RespondWithJSON(w, http.StatusOK, "User updated successfully")
+// decodePatchString decodes a string field of a merge patch. It returns nil if the
+// field is null.
+func decodePatchString(value json.RawMessage) (*string, error) {
+	var text *string
+	err := json.Unmarshal(value, &text)
+	return text, err
 }
 
-// DeleteUserHandler handles the HTTP request for deleting a user.
-func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the JWT token and the database client from the request.
-	token, _, client := ExtractDBAndToken(r)
+// updateUser checks the current password if the update changes the email or the
+// password, hashes the new password and applies the update to the caller. It
+// responds with an error and returns false if the update cannot be made.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, update database.UserUpdate,
+	currentPassword string) (database.User, bool) {
+	principal := ExtractPrincipal(r)
 
-	// If the token is nil, return immediately.
-	if token == nil {
-		return
+	// An email that does not change needs no password
+	if update.Email != nil && *update.Email == principal.User.Email {
+		update.Email = nil
 	}
 
-	// Extract the issuer from the token claims.
-	issuer := token.Claims.(jwt.MapClaims)["Issuer"].(string)
+	// Make sure the caller knows the password before changing the credentials
+	if update.Email != nil || update.Password != nil {
+		if currentPassword == "" {
+			RespondWithError(w, r, http.StatusBadRequest, "The current password is required to change the email or password")
+			return database.User{}, false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(principal.User.Password), []byte(currentPassword)) != nil {
+			RespondWithError(w, r, http.StatusForbidden, "Invalid current password")
+			return database.User{}, false
+		}
+	}
 
-	// If the issuer is "go-chat-application-refresh", respond with an error
-	// because a JWT access token is required, not a refresh token.
-	if issuer == "go-chat-application-refresh" {
-		RespondWithError(w, http.StatusUnauthorized,
-			"Using JWT refresh token when JWT access token is required")
-		return
+	// Hash the new password using bcrypt
+	if update.Password != nil {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*update.Password), s.Config.BcryptCost)
+		if err != nil {
+			RespondWithError(w, r, http.StatusInternalServerError, "Unable to hash password")
+			return database.User{}, false
+		}
+		hashed := string(hashedPassword)
+		update.Password = &hashed
 	}
 
-	// Extract the user ID from the token claims and remove the "ObjectID(" and ")" parts.
-	userID := token.Claims.(jwt.MapClaims)["Subject"].(string)
-	userID = strings.TrimPrefix(userID, "ObjectID(\"")
-	userID = strings.TrimSuffix(userID, "\")")
+	// Nothing to write if no field changes
+	if update.Empty() {
+		return principal.User, true
+	}
 
-	// If the user ID is empty, respond with an error.
-	if userID == "" {
-		RespondWithError(w, http.StatusUnauthorized, "Unable to get user ID from JWT token")
-		return
+	// Update the user in the database
+	user, err := s.Users.UpdateUser(r.Context(), principal.UserID, update)
+	if errors.Is(err, database.ErrEmailInUse) {
+		RespondWithError(w, r, http.StatusConflict, "Email already in use")
+		return database.User{}, false
 	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		RespondWithError(w, r, http.StatusNotFound, "User not found")
+		return database.User{}, false
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to update user", err)
+		return database.User{}, false
+	}
+
+	return user, true
+}
+
+// DeleteUserHandler handles the HTTP request for deleting a user.
+func (s *Server) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated caller from the request.
+	principal := ExtractPrincipal(r)
 
 	// Try to delete the user with the given user ID. If an error occurs, respond with an error.
-	if err := client.DeleteUser(userID); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to delete user")
+	if err := s.Users.DeleteUser(r.Context(), principal.UserID.Hex()); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to delete user", err)
 		return
 	}
 
@@ -244,11 +520,7 @@ func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // LoginUserHandler handles user login requests
-func LoginUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the database client from the request context
-	ctx := r.Context()
-	client, _ := ctx.Value(config.ApiCfg.DB).(*database.MongoDBClient)
-
+func (s *Server) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Define the structure for the request parameters
 	var params struct {
 		Email    string `json:"email"`
@@ -258,95 +530,251 @@ func LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Decode the request body into the params structure
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&params); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	// Retrieve all users from the database
-	users, err := client.GetAllUsers()
+	// Find the user with the given email
+	user, err := s.Users.GetUserByEmail(r.Context(), params.Email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		s.Metrics.LoginFailed()
+		RespondWithError(w, r, http.StatusUnauthorized, "Invalid email")
+		return
+	}
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Unable to get users")
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get user", err)
 		return
 	}
 
-	// Iterate over the users to find a match for the email
-	for _, user := range users {
-		if params.Email == user.Email {
-			// Check if the provided password matches the user's password
-			if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)); err != nil {
-				RespondWithError(w, http.StatusUnauthorized, "Invalid password")
-			}
+	// Check if the provided password matches the user's password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)); err != nil {
+		s.Metrics.LoginFailed()
+		RespondWithError(w, r, http.StatusUnauthorized, "Invalid password")
+		return
+	}
 
-			// Generate UUIDs for the access and refresh tokens
-			accessTokenID, err := uuid.NewUUID()
-			if err != nil {
-				RespondWithError(w, http.StatusInternalServerError, "Unable to generate access token")
-				return
-			}
+	// Record the user in the access log
+	logging.WithUser(r.Context(), user.ID.Hex())
 
-			refreshTokenID, err := uuid.NewUUID()
-			if err != nil {
-				RespondWithError(w, http.StatusInternalServerError, "Unable to generate refresh token")
-				return
-			}
+	// Generate a session ID shared by the access and refresh tokens
+	sessionID, err := uuid.NewUUID()
+	if err != nil {
+		RespondWithError(w, r, http.StatusInternalServerError, "Unable to generate session")
+		return
+	}
 
-			// Define the claims for the access and refresh tokens
-			accessClaims := jwt.MapClaims{
-				"ID":        accessTokenID.String(),
-				"Issuer":    "go-chat-application-access",
-				"Subject":   user.ID.String(),
-				"IssuedAt":  jwt.NewNumericDate(time.Now()),
-				"ExpiresAt": jwt.NewNumericDate(time.Now().Add(AccessExpiration)),
-				"Revoked":   false,
-			}
+	// Issue the access token
+	signedToken, _, err := s.Tokens.IssueToken(r.Context(), tokenPackage.AccessTokenType, user.ID.Hex(),
+		sessionID.String(), s.Config.AccessExpiration)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to sign access token", err)
+		return
+	}
 
-			refreshClaims := jwt.MapClaims{
-				"ID":        refreshTokenID.String(),
-				"Issuer":    "go-chat-application-refresh",
-				"Subject":   user.ID.String(),
-				"IssuedAt":  jwt.NewNumericDate(time.Now()),
-				"ExpiresAt": jwt.NewNumericDate(time.Now().Add(RefreshExpiration)),
-				"Revoked":   false,
-			}
+	// Issue the refresh token
+	signedRefreshToken, _, err := s.Tokens.IssueToken(r.Context(), tokenPackage.RefreshTokenType,
+		user.ID.Hex(), sessionID.String(), s.Config.RefreshExpiration)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to sign refresh token", err)
+		return
+	}
 
-			// Create the JWTs with the defined claims
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-			refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
+	// Create a map to hold the response data
+	responseMap := map[string]interface{}{
+		"id":            user.ID.Hex(),
+		"name":          user.Name,
+		"email":         user.Email,
+		"access_token":  signedToken,
+		"refresh_token": signedRefreshToken,
+	}
 
-			// Add the tokens to the token map
-			tokenPackage.AddTokenToMap(token)
-			tokenPackage.AddTokenToMap(refreshToken)
+	// Respond with the created map as JSON
+	s.Metrics.LoginSucceeded()
+	RespondWithJSON(w, http.StatusOK, responseMap)
+}
 
-			// Generate a signed string from the access token using the JWT secret
-			signedToken, err := token.SignedString([]byte(config.ApiCfg.JwtSecret))
-			if err != nil {
-				// If there's an error, respond with an internal server error message
-				RespondWithError(w, http.StatusInternalServerError, "Unable to sign access token")
-				return
-			}
+// RefreshTokenHandler exchanges a JWT refresh token for a new JWT access token.
+// When refresh token rotation is enabled, the refresh token is also replaced and
+// presenting an already rotated refresh token revokes the whole session.
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the JWT token from the request header
+	tokenString := tokenPackage.ExtractJWTTokenFromHeader(r)
+	if tokenString == "" {
+		RespondWithError(w, r, http.StatusUnauthorized, "Invalid or missing JWT token")
+		return
+	}
 
-			// Generate a signed string from the refresh token using the JWT secret
-			signedRefreshToken, err := refreshToken.SignedString([]byte(config.ApiCfg.JwtSecret))
-			if err != nil {
-				// If there's an error, respond with an internal server error message
-				RespondWithError(w, http.StatusInternalServerError, "Unable to sign refresh token")
+	// Parse and validate the JWT token, including its expiry
+	claims, err := s.Tokens.ParseAndValidateJWTToken(r.Context(), tokenString)
+	if err != nil {
+		RespondWithError(w, r, http.StatusUnauthorized, "Invalid or missing JWT token")
+		return
+	}
+
+	// Only refresh tokens may be exchanged
+	if claims.TokenType != tokenPackage.RefreshTokenType {
+		RespondWithError(w, r, http.StatusUnauthorized,
+			"Using JWT access token when JWT refresh token is required")
+		return
+	}
+
+	// Check the stored copy of the token for revocation
+	sessionID := claims.SessionID
+	record, err := s.Tokens.Store.Get(r.Context(), claims.ID)
+	if err != nil && !errors.Is(err, tokenPackage.ErrTokenNotFound) {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to check JWT refresh token", err)
+		return
+	}
+	if record.Revoked {
+		// A rotated refresh token being used again means it was stolen, so end the session
+		if record.ReplacedBy != "" && sessionID != "" {
+			if err := s.Tokens.Store.RevokeSession(r.Context(), sessionID); err != nil {
+				RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke session", err)
 				return
 			}
+			RespondWithError(w, r, http.StatusUnauthorized, "JWT refresh token reuse detected")
+			return
+		}
+		RespondWithError(w, r, http.StatusUnauthorized, "JWT refresh token has been revoked")
+		return
+	}
 
-			// Create a map to hold the response data
-			responseMap := map[string]interface{}{
-				"id":            accessClaims["Subject"],
-				"name":          user.Name,
-				"email":         user.Email,
-				"access_token":  signedToken,
-				"refresh_token": signedRefreshToken,
+	// Check that the user still exists and has not revoked the token since it was issued
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		RespondWithError(w, r, http.StatusUnauthorized, "Unable to get user ID from JWT token")
+		return
+	}
+	user, err := s.Users.GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		RespondWithError(w, r, http.StatusUnauthorized, "User no longer exists")
+		return
+	}
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get user", err)
+		return
+	}
+	if auth.TokenRevoked(user, claims) {
+		RespondWithError(w, r, http.StatusUnauthorized, "JWT refresh token has been revoked")
+		return
+	}
+
+	// Claim the old refresh token before anything is issued for it. Only one of several
+	// concurrent refreshes with the same token wins; the others are treated as reuse.
+	var refreshClaims *tokenPackage.Claims
+	if s.Config.RotateRefreshTokens {
+		refreshClaims, err = tokenPackage.NewClaims(tokenPackage.RefreshTokenType, claims.UserID, sessionID,
+			s.Config.RefreshExpiration)
+		if err != nil {
+			RespondWithError(w, r, http.StatusInternalServerError, "Unable to sign refresh token")
+			return
+		}
+
+		// Make sure the old token is stored so that its replacement is remembered
+		if err := s.Tokens.Store.Add(r.Context(), tokenPackage.RecordFromClaims(claims)); err != nil {
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke refresh token", err)
+			return
+		}
+		claimed, err := s.Tokens.Store.RevokeIfActive(r.Context(), claims.ID, refreshClaims.ID)
+		if err != nil {
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke refresh token", err)
+			return
+		}
+		if !claimed {
+			if sessionID != "" {
+				if err := s.Tokens.Store.RevokeSession(r.Context(), sessionID); err != nil {
+					RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke session", err)
+					return
+				}
 			}
+			RespondWithError(w, r, http.StatusUnauthorized, "JWT refresh token reuse detected")
+			return
+		}
+	}
+
+	// Issue a new access token for the same user and session
+	signedToken, _, err := s.Tokens.IssueToken(r.Context(), tokenPackage.AccessTokenType, claims.UserID,
+		sessionID, s.Config.AccessExpiration)
+	if err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to sign access token", err)
+		return
+	}
+
+	responseMap := map[string]interface{}{
+		"access_token": signedToken,
+	}
+
+	// Issue the refresh token that replaces the old one
+	if refreshClaims != nil {
+		signedRefreshToken, err := s.Tokens.IssueClaims(r.Context(), refreshClaims)
+		if err != nil {
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to sign refresh token", err)
+			return
+		}
+		responseMap["refresh_token"] = signedRefreshToken
+	}
 
-			// Respond with the created map as JSON
-			RespondWithJSON(w, http.StatusOK, responseMap)
+	// Respond with the new tokens
+	RespondWithJSON(w, http.StatusOK, responseMap)
+}
+
+// LogoutUserHandler revokes the presented JWT access token together with the refresh
+// token issued alongside it
+func (s *Server) LogoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the claims of the authenticated caller's token from the request
+	principal := ExtractPrincipal(r)
+	claims := principal.Claims
+
+	// Make sure the token is stored so that its revocation is remembered
+	if err := s.Tokens.Store.Add(r.Context(), tokenPackage.RecordFromClaims(claims)); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT token", err)
+		return
+	}
+
+	// Revoke the token and every other token of the same session
+	if err := s.Tokens.Store.Revoke(r.Context(), claims.ID, ""); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT token", err)
+		return
+	}
+	if claims.SessionID != "" {
+		if err := s.Tokens.Store.RevokeSession(r.Context(), claims.SessionID); err != nil {
+			RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke session", err)
 			return
 		}
 	}
-	// If the function hasn't returned by this point, the email is invalid
-	RespondWithError(w, http.StatusUnauthorized, "Invalid email")
+
+	// Old-format tokens have no session to revoke them by, so revoke every one of them
+	if err := s.Users.RevokeUserTokens(r.Context(), principal.UserID, time.Now(), true); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT token", err)
+		return
+	}
+
+	// Respond with a success message
+	RespondWithJSON(w, http.StatusOK, "User logged out successfully")
+}
+
+// LogoutAllUserHandler revokes every JWT token issued to the caller
+func (s *Server) LogoutAllUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the claims of the authenticated caller's token from the request
+	principal := ExtractPrincipal(r)
+	claims := principal.Claims
+
+	// Make sure the presented token is stored so that its revocation is remembered
+	if err := s.Tokens.Store.Add(r.Context(), tokenPackage.RecordFromClaims(claims)); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT tokens", err)
+		return
+	}
+
+	// Revoke every token issued to the same user, including the ones missing from the store
+	if err := s.Users.RevokeUserTokens(r.Context(), principal.UserID, time.Now(), false); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT tokens", err)
+		return
+	}
+	if err := s.Tokens.Store.RevokeSubject(r.Context(), claims.UserID); err != nil {
+		RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to revoke JWT tokens", err)
+		return
+	}
+
+	// Respond with a success message
+	RespondWithJSON(w, http.StatusOK, "User logged out of all sessions successfully")
 }
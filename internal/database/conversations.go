@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrConversationNotFound is returned when no conversation matches the given ID.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ErrUnknownUsers is returned when a conversation is created with members that do not exist.
+var ErrUnknownUsers = errors.New("one or more users do not exist")
+
+// HasUser reports whether the given user is a member of the conversation.
+func (c Conversation) HasUser(userID primitive.ObjectID) bool {
+	for _, id := range c.Users {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateConversation creates a new conversation with the given name and members.
+func (client *MongoDBClient) CreateConversation(ctx context.Context, name string,
+	users []primitive.ObjectID) (_ Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "CreateConversation", "conversations")
+	defer func() { err = end(err) }()
+
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return Conversation{}, fmt.Errorf("database is nil")
+	}
+
+	// Create a new conversation.
+	conversation := Conversation{
+		Name:      name,
+		Users:     users,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// Check the members and insert the conversation in one transaction, so that
+	// a member deleted in the meantime is not added.
+	err = client.WithTransaction(ctx, func(ctx context.Context) error {
+		// Check that every member refers to an existing user.
+		count, err := client.Database(client.DBName).Collection("users").
+			CountDocuments(ctx, bson.M{"_id": bson.M{"$in": users}})
+		if err != nil {
+			return err
+		}
+		if count != int64(len(users)) {
+			return ErrUnknownUsers
+		}
+
+		// Insert the new conversation into the database.
+		response, err := collection.InsertOne(ctx, conversation)
+		if err != nil {
+			return err
+		}
+		conversation.ID = response.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// GetConversation retrieves the conversation with the given ID.
+func (client *MongoDBClient) GetConversation(ctx context.Context, id string) (_ Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "GetConversation", "conversations")
+	defer func() { err = end(err) }()
+
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return Conversation{}, fmt.Errorf("database is nil")
+	}
+
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+
+	// Find the conversation and decode it.
+	var conversation Conversation
+	err = collection.FindOne(ctx, bson.M{"_id": conversationID}).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return Conversation{}, ErrConversationNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// GetConversationsForUser retrieves every conversation the given user is a member of,
+// most recently updated first.
+func (client *MongoDBClient) GetConversationsForUser(ctx context.Context,
+	userID primitive.ObjectID) (_ []Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "GetConversationsForUser", "conversations")
+	defer func() { err = end(err) }()
+
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return []Conversation{}, fmt.Errorf("database is nil")
+	}
+
+	conversations := []Conversation{}
+
+	// Find all conversations that list the user as a member.
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"users": userID}, opts)
+	if err != nil {
+		return []Conversation{}, err
+	}
+	defer cursor.Close(ctx)
+
+	// Decode the cursor into the conversations slice.
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return []Conversation{}, err
+	}
+
+	return conversations, nil
+}
+
+// RenameConversation changes the name of the conversation with the given ID.
+func (client *MongoDBClient) RenameConversation(ctx context.Context, id, name string) (_ Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "RenameConversation", "conversations")
+	defer func() { err = end(err) }()
+
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return Conversation{}, fmt.Errorf("database is nil")
+	}
+
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+
+	// Define the filter and update operation for the update query.
+	filter := bson.M{"_id": conversationID}
+	update := bson.M{
+		"$set": bson.M{
+			"name":       name,
+			"updated_at": time.Now(),
+		},
+	}
+
+	// Execute the update query and return the updated document.
+	var conversation Conversation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return Conversation{}, ErrConversationNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// DeleteConversation deletes the conversation with the given ID and its messages.
+func (client *MongoDBClient) DeleteConversation(ctx context.Context, id string) (err error) {
+	ctx, end := client.startOperation(ctx, "DeleteConversation", "conversations")
+	defer func() { err = end(err) }()
+
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return fmt.Errorf("database is nil")
+	}
+
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrConversationNotFound
+	}
+
+	// Delete the conversation and its messages together, so that no message refers
+	// to a missing conversation.
+	return client.WithTransaction(ctx, func(ctx context.Context) error {
+		// Execute the delete query.
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": conversationID})
+		if err != nil {
+			return err
+		}
+
+		// Check if a conversation was deleted.
+		if result.DeletedCount == 0 {
+			return ErrConversationNotFound
+		}
+
+		// Delete the messages of the conversation.
+		_, err = client.Database(client.DBName).Collection("messages").DeleteMany(ctx,
+			bson.M{"conversation_id": conversationID})
+		return err
+	})
+}
+
+// AddConversationUser adds the given user to the members of the conversation.
+func (client *MongoDBClient) AddConversationUser(ctx context.Context, id string,
+	userID primitive.ObjectID) (_ Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "AddConversationUser", "conversations")
+	defer func() { err = end(err) }()
+
+	// Check the user and add them in one transaction, so that a user deleted in
+	// the meantime is not added.
+	var conversation Conversation
+	err = client.WithTransaction(ctx, func(ctx context.Context) error {
+		// Check that the user exists.
+		err := client.Database(client.DBName).Collection("users").
+			FindOne(ctx, bson.M{"_id": userID}).Err()
+		if err == mongo.ErrNoDocuments {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		conversation, err = client.updateConversationUsers(ctx, id, bson.M{"$addToSet": bson.M{"users": userID}})
+		return err
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// RemoveConversationUser removes the given user from the members of the conversation.
+func (client *MongoDBClient) RemoveConversationUser(ctx context.Context, id string,
+	userID primitive.ObjectID) (_ Conversation, err error) {
+	ctx, end := client.startOperation(ctx, "RemoveConversationUser", "conversations")
+	defer func() { err = end(err) }()
+
+	return client.updateConversationUsers(ctx, id, bson.M{"$pull": bson.M{"users": userID}})
+}
+
+// updateConversationUsers applies a membership update to the conversation and returns the updated document.
+func (client *MongoDBClient) updateConversationUsers(ctx context.Context, id string,
+	update bson.M) (Conversation, error) {
+	// Get the conversations collection from the database.
+	collection := client.Database(client.DBName).Collection("conversations")
+	if collection == nil {
+		return Conversation{}, fmt.Errorf("database is nil")
+	}
+
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+
+	// Bump the updated_at timestamp along with the membership change.
+	update["$set"] = bson.M{"updated_at": time.Now()}
+
+	// Execute the update query and return the updated document.
+	var conversation Conversation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": conversationID}, update, opts).
+		Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return Conversation{}, ErrConversationNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
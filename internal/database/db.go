@@ -2,28 +2,76 @@ package database
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"go-chat-application/logging"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-type DBTX interface {
-	Database(name string) *mongo.Database
-	StartSession() (mongo.SessionContext, error)
-}
-
 type MongoDBClient struct {
 	*mongo.Client
 	DBName string
+	// Timeouts bounds how long each repository operation may take.
+	Timeouts OperationTimeouts
+
+	// noTransactions makes sure the standalone fallback of WithTransaction is only reported once.
+	noTransactions sync.Once
 }
 
 func (c *MongoDBClient) Database(name string) *mongo.Database {
 	return c.Client.Database(name)
 }
 
-func (c *MongoDBClient) StartSession() (mongo.SessionContext, error) {
+// CheckHealth pings the primary of the MongoDB deployment.
+func (c *MongoDBClient) CheckHealth(ctx context.Context) error {
+	return c.Client.Ping(ctx, readpref.Primary())
+}
+
+// StartSession starts a session bound to ctx. The caller must end it with
+// EndSession once it is done.
+func (c *MongoDBClient) StartSession(ctx context.Context) (mongo.SessionContext, error) {
 	session, err := c.Client.StartSession()
 	if err != nil {
 		return nil, err
 	}
-	return mongo.NewSessionContext(context.Background(), session), nil
+	return mongo.NewSessionContext(ctx, session), nil
+}
+
+// WithTransaction runs fn in a transaction, so that its writes are applied
+// together or not at all. fn must do all its work with the context it is given
+// and may be called again when the transaction hits a transient error.
+//
+// Transactions need a replica set or a sharded cluster. On a standalone server,
+// which rejects them before anything is written, fn runs without a transaction
+// and a warning is logged the first time.
+func (c *MongoDBClient) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	sc, err := c.StartSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sc.EndSession(context.Background())
+
+	_, err = sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if isTransactionNotSupported(err) {
+		c.noTransactions.Do(func() {
+			logging.FromContext(ctx).Warn("MongoDB does not support transactions, so multi-document writes " +
+				"are not atomic; run a replica set to enable them")
+		})
+		return fn(ctx)
+	}
+	return err
+}
+
+// isTransactionNotSupported reports whether err was returned because the server
+// is a standalone instance, which does not support transactions.
+func isTransactionNotSupported(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 20 &&
+		strings.Contains(cmdErr.Message, "Transaction numbers are only allowed")
 }
@@ -0,0 +1,534 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDB is a Repository that keeps every document in memory. It behaves like
+// MongoDBClient and is meant for tests and local development without MongoDB.
+type MemoryDB struct {
+	mu            sync.RWMutex
+	users         map[primitive.ObjectID]User
+	conversations map[primitive.ObjectID]Conversation
+	messages      []Message
+}
+
+// NewMemoryDB creates an empty in-memory database.
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:         make(map[primitive.ObjectID]User),
+		conversations: make(map[primitive.ObjectID]Conversation),
+	}
+}
+
+// CheckHealth always succeeds because the data lives in the process.
+func (db *MemoryDB) CheckHealth(ctx context.Context) error {
+	return nil
+}
+
+// CreateUser creates a new user in the database. The password must already be hashed.
+func (db *MemoryDB) CreateUser(ctx context.Context, name, email, hashedPassword string) (User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Check if a user with the same email already exists.
+	for _, user := range db.users {
+		if user.Email == email {
+			return User{}, ErrEmailInUse
+		}
+	}
+
+	user := User{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Email:     email,
+		Password:  hashedPassword,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	db.users[user.ID] = user
+
+	return user, nil
+}
+
+// GetUserByID retrieves the user with the given ID.
+func (db *MemoryDB) GetUserByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	user, ok := db.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail retrieves the user with the given email.
+func (db *MemoryDB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, user := range db.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+// ListUsers retrieves a page of users. It also returns whether more users follow
+// the page, and the number of users matching the search across all pages.
+func (db *MemoryDB) ListUsers(ctx context.Context, page UserPage) ([]User, bool, int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Match the users whose name or display name starts with the search text, or
+	// whose email is the search text.
+	search := strings.ToLower(page.Search)
+	users := []User{}
+	for _, user := range db.users {
+		if strings.HasPrefix(strings.ToLower(user.Name), search) ||
+			strings.HasPrefix(strings.ToLower(user.DisplayName), search) ||
+			strings.EqualFold(user.Email, page.Search) {
+			users = append(users, user)
+		}
+	}
+	total := int64(len(users))
+
+	// Sort by the requested field, using the ID to order users with the same value.
+	less := func(a, b User) bool {
+		if page.Sort == UserSortCreatedAt && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if page.Sort != UserSortCreatedAt && a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	}
+	if page.Descending {
+		ascending := less
+		less = func(a, b User) bool { return ascending(b, a) }
+	}
+	sort.Slice(users, func(i, j int) bool { return less(users[i], users[j]) })
+
+	// Continue after the last user of the previous page.
+	if page.After != nil {
+		start := sort.Search(len(users), func(i int) bool { return less(*page.After, users[i]) })
+		users = users[start:]
+	}
+
+	// Cut the page.
+	hasMore := int64(len(users)) > page.Limit
+	if hasMore {
+		users = users[:page.Limit]
+	}
+
+	return users, hasMore, total, nil
+}
+
+// UpdateUser sets the given fields of the user with the given ID, leaving the
+// others untouched, and returns the updated user.
+func (db *MemoryDB) UpdateUser(ctx context.Context, id primitive.ObjectID, update UserUpdate) (User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	user, ok := db.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if update.Email != nil {
+		for _, other := range db.users {
+			if other.ID != id && other.Email == *update.Email {
+				return User{}, ErrEmailInUse
+			}
+		}
+	}
+
+	// An expired status is replaced rather than updated field by field.
+	now := time.Now()
+	if update.Status != nil && user.Status.Expired(now) {
+		user.Status = nil
+	}
+
+	// Apply the changed fields; cleared fields map to nil.
+	for field, value := range update.fields() {
+		text, _ := value.(string)
+		switch field {
+		case "name":
+			user.Name = text
+		case "email":
+			user.Email = text
+		case "password":
+			user.Password = text
+		case "display_name":
+			user.DisplayName = text
+		case "avatar_url":
+			user.AvatarURL = text
+		case "avatar_image_id":
+			user.AvatarImageID = text
+		case "bio":
+			user.Bio = text
+		case "timezone":
+			user.Timezone = text
+		case "locale":
+			user.Locale = text
+		case "status":
+			user.Status = nil
+		case "status.text", "status.emoji", "status.expires_at":
+			status := UserStatus{}
+			if user.Status != nil {
+				status = *user.Status
+			}
+			switch field {
+			case "status.text":
+				status.Text = text
+			case "status.emoji":
+				status.Emoji = text
+			case "status.expires_at":
+				status.ExpiresAt, _ = value.(time.Time)
+			}
+			user.Status = &status
+		}
+	}
+
+	// Remove a status left without a text and an emoji.
+	if user.Status != nil && user.Status.Text == "" && user.Status.Emoji == "" {
+		user.Status = nil
+	}
+	user.UpdatedAt = now
+	db.users[id] = user
+
+	return user, nil
+}
+
+// RevokeUserTokens invalidates the tokens issued to the user before the given time,
+// or only the old-format ones if legacyOnly is set. A later revocation time that
+// is already stored is kept.
+func (db *MemoryDB) RevokeUserTokens(ctx context.Context, id primitive.ObjectID, at time.Time,
+	legacyOnly bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	user, ok := db.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	revokedAt := &user.TokensRevokedAt
+	if legacyOnly {
+		revokedAt = &user.LegacyTokensRevokedAt
+	}
+	if at.After(*revokedAt) {
+		*revokedAt = at
+	}
+	db.users[id] = user
+
+	return nil
+}
+
+// DeleteUser deletes the user with the given ID, removes them from every
+// conversation and tombstones the messages they sent.
+func (db *MemoryDB) DeleteUser(ctx context.Context, id string) error {
+	// Convert the string ID to MongoDB ObjectID.
+	userID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	delete(db.users, userID)
+
+	// Remove the user from the members of every conversation.
+	now := time.Now()
+	for id, conversation := range db.conversations {
+		if !conversation.HasUser(userID) {
+			continue
+		}
+		users := []primitive.ObjectID{}
+		for _, member := range conversation.Users {
+			if member != userID {
+				users = append(users, member)
+			}
+		}
+		conversation.Users = users
+		conversation.UpdatedAt = now
+		db.conversations[id] = conversation
+	}
+
+	// Keep the user's messages in the history but clear their content.
+	for i, message := range db.messages {
+		if message.SenderID == userID && message.DeletedAt.IsZero() {
+			db.messages[i].Content = ""
+			db.messages[i].DeletedAt = now
+		}
+	}
+
+	return nil
+}
+
+// CreateConversation creates a new conversation with the given name and members.
+func (db *MemoryDB) CreateConversation(ctx context.Context, name string,
+	users []primitive.ObjectID) (Conversation, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Check that every member refers to an existing user.
+	for _, id := range users {
+		if _, ok := db.users[id]; !ok {
+			return Conversation{}, ErrUnknownUsers
+		}
+	}
+
+	conversation := Conversation{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Users:     append([]primitive.ObjectID{}, users...),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	db.conversations[conversation.ID] = conversation
+
+	return copyConversation(conversation), nil
+}
+
+// GetConversation retrieves the conversation with the given ID.
+func (db *MemoryDB) GetConversation(ctx context.Context, id string) (Conversation, error) {
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	conversation, ok := db.conversations[conversationID]
+	if !ok {
+		return Conversation{}, ErrConversationNotFound
+	}
+	return copyConversation(conversation), nil
+}
+
+// GetConversationsForUser retrieves every conversation the given user is a member of,
+// most recently updated first.
+func (db *MemoryDB) GetConversationsForUser(ctx context.Context, userID primitive.ObjectID) ([]Conversation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	conversations := []Conversation{}
+	for _, conversation := range db.conversations {
+		if conversation.HasUser(userID) {
+			conversations = append(conversations, copyConversation(conversation))
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].UpdatedAt.After(conversations[j].UpdatedAt)
+	})
+
+	return conversations, nil
+}
+
+// RenameConversation changes the name of the conversation with the given ID.
+func (db *MemoryDB) RenameConversation(ctx context.Context, id, name string) (Conversation, error) {
+	return db.updateConversation(id, func(conversation *Conversation) {
+		conversation.Name = name
+	})
+}
+
+// DeleteConversation deletes the conversation with the given ID and its messages.
+func (db *MemoryDB) DeleteConversation(ctx context.Context, id string) error {
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrConversationNotFound
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.conversations[conversationID]; !ok {
+		return ErrConversationNotFound
+	}
+	delete(db.conversations, conversationID)
+
+	// Delete the messages of the conversation.
+	messages := []Message{}
+	for _, message := range db.messages {
+		if message.ConversationID != conversationID {
+			messages = append(messages, message)
+		}
+	}
+	db.messages = messages
+
+	return nil
+}
+
+// AddConversationUser adds the given user to the members of the conversation.
+func (db *MemoryDB) AddConversationUser(ctx context.Context, id string,
+	userID primitive.ObjectID) (Conversation, error) {
+	db.mu.RLock()
+	_, ok := db.users[userID]
+	db.mu.RUnlock()
+	if !ok {
+		return Conversation{}, ErrUserNotFound
+	}
+
+	return db.updateConversation(id, func(conversation *Conversation) {
+		if !conversation.HasUser(userID) {
+			conversation.Users = append(conversation.Users, userID)
+		}
+	})
+}
+
+// RemoveConversationUser removes the given user from the members of the conversation.
+func (db *MemoryDB) RemoveConversationUser(ctx context.Context, id string,
+	userID primitive.ObjectID) (Conversation, error) {
+	return db.updateConversation(id, func(conversation *Conversation) {
+		users := []primitive.ObjectID{}
+		for _, member := range conversation.Users {
+			if member != userID {
+				users = append(users, member)
+			}
+		}
+		conversation.Users = users
+	})
+}
+
+// updateConversation applies the update to the conversation, bumps its updated_at
+// timestamp and returns the updated document.
+func (db *MemoryDB) updateConversation(id string, update func(*Conversation)) (Conversation, error) {
+	// Convert the string ID to MongoDB ObjectID.
+	conversationID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Conversation{}, ErrConversationNotFound
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	conversation, ok := db.conversations[conversationID]
+	if !ok {
+		return Conversation{}, ErrConversationNotFound
+	}
+	conversation = copyConversation(conversation)
+	update(&conversation)
+	conversation.UpdatedAt = time.Now()
+	db.conversations[conversationID] = conversation
+
+	return copyConversation(conversation), nil
+}
+
+// CreateMessage stores a new message in the given conversation and bumps the
+// conversation's updated_at timestamp. It returns ErrConversationNotFound when the
+// conversation does not exist or the sender is not one of its members.
+func (db *MemoryDB) CreateMessage(ctx context.Context, conversationID, senderID primitive.ObjectID,
+	content string) (Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	conversation, ok := db.conversations[conversationID]
+	if !ok || !conversation.HasUser(senderID) {
+		return Message{}, ErrConversationNotFound
+	}
+
+	message := Message{
+		ID:             primitive.NewObjectID(),
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        content,
+		CreatedAt:      time.Now(),
+	}
+	db.messages = append(db.messages, message)
+
+	// Mark the conversation as updated so it sorts first in the member's list.
+	conversation.UpdatedAt = message.CreatedAt
+	db.conversations[conversationID] = conversation
+
+	return message, nil
+}
+
+// GetMessages retrieves a page of messages from the given conversation in
+// chronological order. The returned bool reports whether more messages exist
+// beyond the page in the direction of the cursor.
+func (db *MemoryDB) GetMessages(ctx context.Context, conversationID primitive.ObjectID,
+	page MessagePage) ([]Message, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Messages are appended in ID order, so walk forwards for "after" and backwards otherwise.
+	messages := []Message{}
+	hasMore := false
+	if !page.After.IsZero() {
+		for _, message := range db.messages {
+			if message.ConversationID != conversationID || message.ID.Hex() <= page.After.Hex() {
+				continue
+			}
+			if int64(len(messages)) == page.Limit {
+				hasMore = true
+				break
+			}
+			messages = append(messages, message)
+		}
+		return messages, hasMore, nil
+	}
+
+	for i := len(db.messages) - 1; i >= 0; i-- {
+		message := db.messages[i]
+		if message.ConversationID != conversationID {
+			continue
+		}
+		if !page.Before.IsZero() && message.ID.Hex() >= page.Before.Hex() {
+			continue
+		}
+		if int64(len(messages)) == page.Limit {
+			hasMore = true
+			break
+		}
+		messages = append([]Message{message}, messages...)
+	}
+
+	return messages, hasMore, nil
+}
+
+// GetMessagesSince retrieves, oldest first, up to limit messages created after the
+// given message ID in any of the given conversations.
+func (db *MemoryDB) GetMessagesSince(ctx context.Context, conversationIDs []primitive.ObjectID,
+	after primitive.ObjectID,
+	limit int64) ([]Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	inConversations := make(map[primitive.ObjectID]bool)
+	for _, id := range conversationIDs {
+		inConversations[id] = true
+	}
+
+	messages := []Message{}
+	for _, message := range db.messages {
+		if int64(len(messages)) == limit {
+			break
+		}
+		if inConversations[message.ConversationID] && message.ID.Hex() > after.Hex() {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
+
+// copyConversation returns a copy of the conversation that does not share its member slice.
+func copyConversation(conversation Conversation) Conversation {
+	conversation.Users = append([]primitive.ObjectID{}, conversation.Users...)
+	return conversation
+}
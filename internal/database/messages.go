@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MessagePage describes which slice of a conversation's history to retrieve.
+// Before and After are message IDs used as exclusive cursors; at most one of them
+// should be set. When neither is set the most recent messages are returned.
+type MessagePage struct {
+	Before primitive.ObjectID
+	After  primitive.ObjectID
+	Limit  int64
+}
+
+// CreateMessage stores a new message in the given conversation and bumps the
+// conversation's updated_at timestamp. It returns ErrConversationNotFound when the
+// conversation does not exist or the sender is not one of its members.
+func (client *MongoDBClient) CreateMessage(ctx context.Context, conversationID, senderID primitive.ObjectID,
+	content string) (_ Message, err error) {
+	ctx, end := client.startOperation(ctx, "CreateMessage", "messages")
+	defer func() { err = end(err) }()
+
+	// Get the messages collection from the database.
+	collection := client.Database(client.DBName).Collection("messages")
+	if collection == nil {
+		return Message{}, fmt.Errorf("database is nil")
+	}
+
+	// Create a new message.
+	message := Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        content,
+		CreatedAt:      time.Now(),
+	}
+
+	// Bump the conversation and insert the message in one transaction.
+	err = client.WithTransaction(ctx, func(ctx context.Context) error {
+		// Mark the conversation as updated so it sorts first in the member's list.
+		// Matching on the sender fails when the conversation was deleted or the
+		// sender removed from it after the handler checked.
+		result, err := client.Database(client.DBName).Collection("conversations").UpdateOne(ctx,
+			bson.M{"_id": conversationID, "users": senderID},
+			bson.M{"$set": bson.M{"updated_at": message.CreatedAt}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrConversationNotFound
+		}
+
+		// Insert the new message into the database.
+		response, err := collection.InsertOne(ctx, message)
+		if err != nil {
+			return err
+		}
+		message.ID = response.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return message, nil
+}
+
+// GetMessages retrieves a page of messages from the given conversation in
+// chronological order. The returned bool reports whether more messages exist
+// beyond the page in the direction of the cursor.
+func (client *MongoDBClient) GetMessages(ctx context.Context, conversationID primitive.ObjectID,
+	page MessagePage) (_ []Message, _ bool, err error) {
+	ctx, end := client.startOperation(ctx, "GetMessages", "messages")
+	defer func() { err = end(err) }()
+
+	// Get the messages collection from the database.
+	collection := client.Database(client.DBName).Collection("messages")
+	if collection == nil {
+		return []Message{}, false, fmt.Errorf("database is nil")
+	}
+
+	// Define the filter and sort order for the query. ObjectIDs increase over
+	// time, so they double as a stable cursor.
+	filter := bson.M{"conversation_id": conversationID}
+	sortOrder := -1
+	if !page.After.IsZero() {
+		filter["_id"] = bson.M{"$gt": page.After}
+		sortOrder = 1
+	} else if !page.Before.IsZero() {
+		filter["_id"] = bson.M{"$lt": page.Before}
+	}
+
+	// Fetch one extra message to find out whether another page exists.
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: sortOrder}}).
+		SetLimit(page.Limit + 1)
+
+	messages := []Message{}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return []Message{}, false, err
+	}
+	defer cursor.Close(ctx)
+
+	// Decode the cursor into the messages slice.
+	if err := cursor.All(ctx, &messages); err != nil {
+		return []Message{}, false, err
+	}
+
+	// Drop the extra message if there is one.
+	hasMore := int64(len(messages)) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+
+	// Return the messages oldest first regardless of the query direction.
+	if sortOrder == -1 {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, hasMore, nil
+}
+
+// GetMessagesSince retrieves, oldest first, up to limit messages created after the
+// given message ID in any of the given conversations.
+func (client *MongoDBClient) GetMessagesSince(ctx context.Context, conversationIDs []primitive.ObjectID,
+	after primitive.ObjectID,
+	limit int64) (_ []Message, err error) {
+	ctx, end := client.startOperation(ctx, "GetMessagesSince", "messages")
+	defer func() { err = end(err) }()
+
+	// Get the messages collection from the database.
+	collection := client.Database(client.DBName).Collection("messages")
+	if collection == nil {
+		return []Message{}, fmt.Errorf("database is nil")
+	}
+
+	// Define the filter and sort order for the query.
+	filter := bson.M{
+		"conversation_id": bson.M{"$in": conversationIDs},
+		"_id":             bson.M{"$gt": after},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	messages := []Message{}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return []Message{}, err
+	}
+	defer cursor.Close(ctx)
+
+	// Decode the cursor into the messages slice.
+	if err := cursor.All(ctx, &messages); err != nil {
+		return []Message{}, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-chat-application/logging"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationsCollection records the version of every migration applied to the database.
+const MigrationsCollection = "schema_migrations"
+
+// Migration is one versioned change to the database schema. Down must undo
+// exactly what Up did, and both must succeed when run again after a failure.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// AppliedMigration is the record kept in MigrationsCollection for an applied migration.
+type AppliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations lists the schema migrations in the order they are applied.
+// Append new migrations with the next version; never change applied ones.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique index on users.email",
+		Up: createIndex("users", mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true),
+		}),
+		Down: dropIndex("users", "email_unique"),
+	},
+	{
+		Version:     2,
+		Description: "index on messages.conversation_id and messages.created_at",
+		Up: createIndex("messages", mongo.IndexModel{
+			Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("conversation_id_created_at"),
+		}),
+		Down: dropIndex("messages", "conversation_id_created_at"),
+	},
+	{
+		Version:     3,
+		Description: "index on conversations.users",
+		Up: createIndex("conversations", mongo.IndexModel{
+			Keys:    bson.D{{Key: "users", Value: 1}},
+			Options: options.Index().SetName("users"),
+		}),
+		Down: dropIndex("conversations", "users"),
+	},
+	{
+		Version:     4,
+		Description: "index on users.name for the user directory",
+		Up: createIndex("users", mongo.IndexModel{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("name_id"),
+		}),
+		Down: dropIndex("users", "name_id"),
+	},
+	{
+		Version:     5,
+		Description: "index on users.created_at for the user directory",
+		Up: createIndex("users", mongo.IndexModel{
+			Keys:    bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("created_at_id"),
+		}),
+		Down: dropIndex("users", "created_at_id"),
+	},
+	{
+		Version:     6,
+		Description: "index on messages.conversation_id and messages._id for message history",
+		Up: createIndex("messages", mongo.IndexModel{
+			Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("conversation_id_id"),
+		}),
+		Down: dropIndex("messages", "conversation_id_id"),
+	},
+	// The token store used to create the tokens indexes itself, with the default
+	// names, so the migrations keep those names to adopt the existing indexes.
+	{
+		Version:     7,
+		Description: "TTL index on tokens.expires_at",
+		Up: createIndex("tokens", mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+		}),
+		Down: dropIndex("tokens", "expires_at_1"),
+	},
+	{
+		Version:     8,
+		Description: "index on tokens.session_id",
+		Up: createIndex("tokens", mongo.IndexModel{
+			Keys:    bson.D{{Key: "session_id", Value: 1}},
+			Options: options.Index().SetName("session_id_1"),
+		}),
+		Down: dropIndex("tokens", "session_id_1"),
+	},
+	{
+		Version:     9,
+		Description: "index on tokens.subject",
+		Up: createIndex("tokens", mongo.IndexModel{
+			Keys:    bson.D{{Key: "subject", Value: 1}},
+			Options: options.Index().SetName("subject_1"),
+		}),
+		Down: dropIndex("tokens", "subject_1"),
+	},
+}
+
+// createIndex returns a migration step that creates the index on the collection.
+// Creating an index that already exists with the same options does nothing.
+func createIndex(collection string, index mongo.IndexModel) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().CreateOne(ctx, index)
+		return err
+	}
+}
+
+// dropIndex returns a migration step that drops the named index of the collection.
+// Dropping an index that does not exist does nothing.
+func dropIndex(collection, name string) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+			return nil
+		}
+		return err
+	}
+}
+
+// MigrationStatuses returns every known migration and whether it has been applied.
+func (client *MongoDBClient) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := client.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, migration := range Migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: record.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every migration that has not been applied yet, in order,
+// and returns the ones it applied. It stops at the first failure.
+func (client *MongoDBClient) MigrateUp(ctx context.Context) ([]Migration, error) {
+	applied, err := client.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := client.Database(client.DBName)
+	var done []Migration
+	for _, migration := range Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := migration.Up(ctx, db); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+
+		// Another instance may have applied the migration at the same time, which is fine
+		// because migrations can run twice
+		record := AppliedMigration{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()}
+		_, err := db.Collection(MigrationsCollection).InsertOne(ctx, record)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return done, fmt.Errorf("recording migration %d: %w", migration.Version, err)
+		}
+
+		logging.FromContext(ctx).Info("Applied migration", "version", migration.Version, "description", migration.Description)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations, newest
+// first, and returns the ones it reverted. It stops at the first failure.
+func (client *MongoDBClient) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := client.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db := client.Database(client.DBName)
+	var done []Migration
+	for i := len(Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if err := migration.Down(ctx, db); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+
+		_, err := db.Collection(MigrationsCollection).DeleteOne(ctx, bson.M{"_id": migration.Version})
+		if err != nil {
+			return done, fmt.Errorf("unrecording migration %d: %w", migration.Version, err)
+		}
+
+		logging.FromContext(ctx).Info("Reverted migration", "version", migration.Version, "description", migration.Description)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// appliedMigrations returns the records of the applied migrations by version.
+func (client *MongoDBClient) appliedMigrations(ctx context.Context) (map[int]AppliedMigration, error) {
+	cursor, err := client.Database(client.DBName).Collection(MigrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []AppliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]AppliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
@@ -13,6 +13,71 @@ type User struct {
 	Password  string             `bson:"password"`
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
+
+	// Profile fields shown to other users. Empty fields are not stored.
+	DisplayName string `bson:"display_name,omitempty"`
+	// An avatar is either an external image URL or the ID of an uploaded image.
+	AvatarURL     string      `bson:"avatar_url,omitempty"`
+	AvatarImageID string      `bson:"avatar_image_id,omitempty"`
+	Bio           string      `bson:"bio,omitempty"`
+	Status        *UserStatus `bson:"status,omitempty"`
+	Timezone      string      `bson:"timezone,omitempty"`
+	Locale        string      `bson:"locale,omitempty"`
+
+	// TokensRevokedAt invalidates every token issued to the user before it.
+	TokensRevokedAt time.Time `bson:"tokens_revoked_at,omitempty"`
+	// LegacyTokensRevokedAt invalidates the old-format tokens issued to the user
+	// before it. They carry no session, so they cannot be revoked one at a time.
+	LegacyTokensRevokedAt time.Time `bson:"legacy_tokens_revoked_at,omitempty"`
+}
+
+// UserStatus is a custom status message, optionally with an emoji, that can
+// expire. An expired status is kept in the database but no longer shown.
+type UserStatus struct {
+	Text      string    `bson:"text,omitempty"`
+	Emoji     string    `bson:"emoji,omitempty"`
+	ExpiresAt time.Time `bson:"expires_at,omitempty"`
+}
+
+// Expired reports whether the status has expired at the given time.
+func (s *UserStatus) Expired(now time.Time) bool {
+	return s != nil && !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// UserUpdate lists the fields of a user to change. Nil fields are left as they
+// are. The password must already be hashed.
+type UserUpdate struct {
+	Name     *string
+	Email    *string
+	Password *string
+	ProfileUpdate
+}
+
+// ProfileUpdate lists the profile fields to change. Nil fields are left as they
+// are, and fields set to their zero value are cleared.
+type ProfileUpdate struct {
+	DisplayName   *string
+	AvatarURL     *string
+	AvatarImageID *string
+	Bio           *string
+	Status        *StatusUpdate
+	Timezone      *string
+	Locale        *string
+}
+
+// StatusUpdate lists the status fields to change, which are applied one by one so
+// that concurrent updates of the other fields are kept. Nil fields are left as they
+// are, and fields set to their zero value are cleared. An expired status is cleared
+// before the update, and a status left without a text and an emoji is removed.
+type StatusUpdate struct {
+	Text      *string
+	Emoji     *string
+	ExpiresAt *time.Time
+}
+
+// clears reports whether the update leaves the status without a text and an emoji.
+func (u StatusUpdate) clears() bool {
+	return u.Text != nil && *u.Text == "" && u.Emoji != nil && *u.Emoji == ""
 }
 
 type Conversation struct {
@@ -29,4 +94,6 @@ type Message struct {
 	SenderID       primitive.ObjectID `bson:"sender_id"`
 	Content        string             `bson:"content"`
 	CreatedAt      time.Time          `bson:"created_at"`
+	// DeletedAt is set, and Content cleared, once the sender's account is deleted.
+	DeletedAt time.Time `bson:"deleted_at,omitempty"`
 }
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go-chat-application/logging"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// CommandObserver is told the name, latency and outcome of every MongoDB command.
+type CommandObserver func(command string, duration time.Duration, failed bool)
+
+// NewCommandMonitor returns a MongoDB command monitor that logs every command with
+// the logger carried by the operation's context, so database activity can be
+// matched to the request that caused it. Successful commands are logged at debug
+// level and failed ones as warnings. The observer, if not nil, is called as well.
+func NewCommandMonitor(observe CommandObserver) *event.CommandMonitor {
+	if observe == nil {
+		observe = func(string, time.Duration, bool) {}
+	}
+
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			observe(evt.CommandName, evt.Duration, false)
+			logging.FromContext(ctx).LogAttrs(ctx, slog.LevelDebug, "MongoDB command succeeded",
+				slog.String("command", evt.CommandName),
+				slog.String("database", evt.DatabaseName),
+				slog.Float64("latency_ms", durationMs(evt.Duration)))
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			observe(evt.CommandName, evt.Duration, true)
+			logging.FromContext(ctx).LogAttrs(ctx, slog.LevelWarn, "MongoDB command failed",
+				slog.String("command", evt.CommandName),
+				slog.String("database", evt.DatabaseName),
+				slog.Float64("latency_ms", durationMs(evt.Duration)),
+				slog.String("error", evt.Failure))
+		},
+	}
+}
+
+// durationMs converts the duration to fractional milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrTimeout is returned, wrapped, when a database operation runs out of time.
+var ErrTimeout = errors.New("database operation timed out")
+
+// DefaultOperationTimeout bounds operations when no timeout is configured.
+const DefaultOperationTimeout = 5 * time.Second
+
+// OperationTimeouts bounds how long each repository operation may take.
+type OperationTimeouts struct {
+	// Default applies to every operation without its own timeout.
+	Default time.Duration
+	// Operations maps Repository method names, such as "GetMessages", to their timeout.
+	Operations map[string]time.Duration
+}
+
+// For returns the timeout of the named operation.
+func (t OperationTimeouts) For(operation string) time.Duration {
+	if timeout, ok := t.Operations[operation]; ok {
+		return timeout
+	}
+	if t.Default > 0 {
+		return t.Default
+	}
+	return DefaultOperationTimeout
+}
+
+// IsOperation reports whether name is a method of Repository, so that
+// per-operation settings can be checked when the configuration is loaded.
+func IsOperation(name string) bool {
+	_, ok := reflect.TypeOf((*Repository)(nil)).Elem().MethodByName(name)
+	return ok
+}
+
+// WrapTimeout marks MongoDB errors caused by a deadline as ErrTimeout, keeping
+// the original error in the chain. Other errors are returned unchanged.
+func WrapTimeout(operation string, err error) error {
+	if err != nil && !errors.Is(err, ErrTimeout) && mongo.IsTimeout(err) {
+		return fmt.Errorf("%w: %s: %w", ErrTimeout, operation, err)
+	}
+	return err
+}
+
+var tracer = otel.Tracer("go-chat-application/internal/database")
+
+// startOperation prepares a MongoDBClient method working on the given collection:
+// it applies the operation's timeout and starts its span. The returned function
+// must be called with the method's error once it is done; it ends the span,
+// releases the timeout and returns the error, marked as ErrTimeout if the
+// deadline was exceeded.
+func (client *MongoDBClient) startOperation(ctx context.Context, method, collection string) (context.Context,
+	func(error) error) {
+	ctx, cancel := context.WithTimeout(ctx, client.Timeouts.For(method))
+	ctx, span := tracer.Start(ctx, "MongoDBClient."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBName(client.DBName),
+			semconv.DBMongoDBCollection(collection),
+		))
+
+	return ctx, func(err error) error {
+		defer cancel()
+		err = WrapTimeout(method, err)
+
+		// Missing documents are an expected outcome rather than a failure of the operation
+		if err != nil && !errors.Is(err, ErrUserNotFound) && !errors.Is(err, ErrConversationNotFound) &&
+			!errors.Is(err, ErrUnknownUsers) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores user accounts.
+type UserRepository interface {
+	CreateUser(ctx context.Context, name, email, hashedPassword string) (User, error)
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	ListUsers(ctx context.Context, page UserPage) ([]User, bool, int64, error)
+	UpdateUser(ctx context.Context, id primitive.ObjectID, update UserUpdate) (User, error)
+	RevokeUserTokens(ctx context.Context, id primitive.ObjectID, at time.Time, legacyOnly bool) error
+	DeleteUser(ctx context.Context, id string) error
+}
+
+// ConversationRepository stores conversations and their members.
+type ConversationRepository interface {
+	CreateConversation(ctx context.Context, name string, users []primitive.ObjectID) (Conversation, error)
+	GetConversation(ctx context.Context, id string) (Conversation, error)
+	GetConversationsForUser(ctx context.Context, userID primitive.ObjectID) ([]Conversation, error)
+	RenameConversation(ctx context.Context, id, name string) (Conversation, error)
+	DeleteConversation(ctx context.Context, id string) error
+	AddConversationUser(ctx context.Context, id string, userID primitive.ObjectID) (Conversation, error)
+	RemoveConversationUser(ctx context.Context, id string, userID primitive.ObjectID) (Conversation, error)
+}
+
+// MessageRepository stores the messages sent in conversations.
+type MessageRepository interface {
+	CreateMessage(ctx context.Context, conversationID, senderID primitive.ObjectID, content string) (Message, error)
+	GetMessages(ctx context.Context, conversationID primitive.ObjectID, page MessagePage) ([]Message, bool, error)
+	GetMessagesSince(ctx context.Context, conversationIDs []primitive.ObjectID, after primitive.ObjectID,
+		limit int64) ([]Message, error)
+}
+
+// HealthChecker reports whether the underlying store can serve requests.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Repository gives access to every collection of the application.
+type Repository interface {
+	HealthChecker
+	UserRepository
+	ConversationRepository
+	MessageRepository
+}
+
+// Make sure both implementations satisfy the repository interfaces.
+var _ Repository = (*MongoDBClient)(nil)
+var _ Repository = (*MemoryDB)(nil)
@@ -5,52 +5,44 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// CreateUser creates a new user in the database.
-func (client *MongoDBClient) CreateUser(name, email, password string) (User, error) {
+// CreateUser creates a new user in the database. The password must already be hashed.
+func (client *MongoDBClient) CreateUser(ctx context.Context, name, email, hashedPassword string) (_ User, err error) {
+	ctx, end := client.startOperation(ctx, "CreateUser", "users")
+	defer func() { err = end(err) }()
+
 	// Get the users collection from the database.
 	collection := client.Database(client.DBName).Collection("users")
 	if collection == nil {
 		return User{}, fmt.Errorf("database is nil")
 	}
 
-	// Hash the password using bcrypt.
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return User{}, err
-	}
-
 	// Create a new user.
 	user := User{
 		Name:      name,
 		Email:     email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// Check if a user with the same email already exists.
-	filter := bson.M{"email": email}
-	err = collection.FindOne(context.Background(), filter).Err()
-	if err != mongo.ErrNoDocuments {
-		if err != nil {
-			return User{}, err
-		}
-		return User{}, errors.New("email already in use")
+	// Insert the new user into the database. The unique index on email rejects
+	// a user whose email is already in use.
+	response, err := collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return User{}, ErrEmailInUse
 	}
-
-	// Insert the new user into the database.
-	response, err := collection.InsertOne(context.Background(), user)
 	if err != nil {
-		return User{}, nil
+		return User{}, err
 	}
 
 	// Create a response with the inserted ID.
@@ -66,68 +58,330 @@ func (client *MongoDBClient) CreateUser(name, email, password string) (User, err
 	return userResponse, nil
 }
 
-// GetAllUsers retrieves all users from the database.
-func (client *MongoDBClient) GetAllUsers() ([]User, error) {
+// ErrUserNotFound is returned when no user matches the given ID or email.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrEmailInUse is returned when another user already has the given email.
+var ErrEmailInUse = errors.New("email already in use")
+
+// GetUserByID retrieves the user with the given ID.
+func (client *MongoDBClient) GetUserByID(ctx context.Context, id primitive.ObjectID) (_ User, err error) {
+	ctx, end := client.startOperation(ctx, "GetUserByID", "users")
+	defer func() { err = end(err) }()
+
 	// Get the users collection from the database.
 	collection := client.Database(client.DBName).Collection("users")
 	if collection == nil {
-		return []User{}, fmt.Errorf("database is nil")
+		return User{}, fmt.Errorf("database is nil")
 	}
 
-	var allUsers []User
+	// Find the user and decode it.
+	var user User
+	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
 
-	// Find all users in the collection.
-	cursor, err := collection.Find(context.Background(), bson.M{})
+// GetUserByEmail retrieves the user with the given email.
+func (client *MongoDBClient) GetUserByEmail(ctx context.Context, email string) (_ User, err error) {
+	ctx, end := client.startOperation(ctx, "GetUserByEmail", "users")
+	defer func() { err = end(err) }()
+
+	// Get the users collection from the database.
+	collection := client.Database(client.DBName).Collection("users")
+	if collection == nil {
+		return User{}, fmt.Errorf("database is nil")
+	}
+
+	// Find the user through the unique email index and decode it.
+	var user User
+	err = collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
-		return []User{}, err
+		return User{}, err
 	}
-	defer cursor.Close(context.Background())
 
-	// Decode the cursor into the allUsers slice.
-	err = cursor.All(context.Background(), &allUsers)
+	return user, nil
+}
+
+// Fields users can be sorted by.
+const (
+	UserSortName      = "name"
+	UserSortCreatedAt = "created_at"
+)
+
+// UserPage describes which slice of the user directory to retrieve. Users are
+// ordered by the Sort field and then by ID, and After, when set, is the last
+// user of the previous page. Search, when set, keeps the users whose name or
+// display name starts with it, or whose email is exactly it, ignoring case. Emails
+// are hidden from the directory, so they are never matched by prefix.
+type UserPage struct {
+	Search     string
+	Sort       string
+	Descending bool
+	After      *User
+	Limit      int64
+}
+
+// sortValue returns the value of the page's sort field for the user.
+func (page UserPage) sortValue(user User) interface{} {
+	if page.Sort == UserSortCreatedAt {
+		return user.CreatedAt
+	}
+	return user.Name
+}
+
+// ListUsers retrieves a page of users. It also returns whether more users follow
+// the page, and the number of users matching the search across all pages.
+func (client *MongoDBClient) ListUsers(ctx context.Context, page UserPage) (_ []User, _ bool, _ int64, err error) {
+	ctx, end := client.startOperation(ctx, "ListUsers", "users")
+	defer func() { err = end(err) }()
+
+	// Get the users collection from the database.
+	collection := client.Database(client.DBName).Collection("users")
+	if collection == nil {
+		return []User{}, false, 0, fmt.Errorf("database is nil")
+	}
+
+	// Match the users whose name or display name starts with the search text, or
+	// whose email is the search text.
+	filter := bson.M{}
+	if page.Search != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(page.Search), Options: "i"}
+		email := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(page.Search) + "$", Options: "i"}
+		filter["$or"] = bson.A{bson.M{"name": prefix}, bson.M{"display_name": prefix}, bson.M{"email": email}}
+	}
+
+	// Count the matching users before narrowing the filter down to the page.
+	total, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
-		return []User{}, err
+		return []User{}, false, 0, err
 	}
 
-	return allUsers, nil
+	// Sort by the requested field, using the ID to order users with the same value.
+	field, order, compare := UserSortName, 1, "$gt"
+	if page.Sort == UserSortCreatedAt {
+		field = UserSortCreatedAt
+	}
+	if page.Descending {
+		order, compare = -1, "$lt"
+	}
+
+	// Continue after the last user of the previous page.
+	if page.After != nil {
+		value := page.sortValue(*page.After)
+		after := bson.M{"$or": bson.A{
+			bson.M{field: bson.M{compare: value}},
+			bson.M{field: value, "_id": bson.M{compare: page.After.ID}},
+		}}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+
+	// Fetch one extra user to find out whether another page exists.
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(page.Limit + 1)
+
+	users := []User{}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return []User{}, false, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	// Decode the cursor into the users slice.
+	if err := cursor.All(ctx, &users); err != nil {
+		return []User{}, false, 0, err
+	}
+
+	// Drop the extra user if there is one.
+	hasMore := int64(len(users)) > page.Limit
+	if hasMore {
+		users = users[:page.Limit]
+	}
+
+	return users, hasMore, total, nil
 }
 
-// UpdateUser updates the user with the given ID in the MongoDB database.
-func (client *MongoDBClient) UpdateUser(id, name, email, password string) error {
+// UpdateUser sets the given fields of the user with the given ID, leaving the
+// others untouched, and returns the updated user.
+func (client *MongoDBClient) UpdateUser(ctx context.Context, id primitive.ObjectID,
+	update UserUpdate) (_ User, err error) {
+	ctx, end := client.startOperation(ctx, "UpdateUser", "users")
+	defer func() { err = end(err) }()
+
 	// Get the users collection from the database.
 	collection := client.Database(client.DBName).Collection("users")
 	if collection == nil {
-		return fmt.Errorf("database is nil")
+		return User{}, fmt.Errorf("database is nil")
 	}
 
-	// Convert the string ID to MongoDB ObjectID.
-	originalID, err := primitive.ObjectIDFromHex(id)
+	// An expired status is replaced rather than updated field by field.
+	now := time.Now()
+	statusFields := update.Status != nil && !update.Status.clears()
+	if statusFields {
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": id, "status.expires_at": bson.M{"$lte": now}},
+			bson.M{"$unset": bson.M{"status": ""}})
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	// Set the given fields and remove the cleared ones, so that empty fields are not
+	// stored. Status fields are set one by one to keep concurrent changes of the others.
+	set, unset := bson.M{"updated_at": now}, bson.M{}
+	for field, value := range update.fields() {
+		if value == nil {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	changes := bson.M{"$set": set}
+	if len(unset) > 0 {
+		changes["$unset"] = unset
+	}
+
+	// Execute the update query and return the updated document. The unique index
+	// on email rejects an email that is already in use.
+	var user User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, changes, opts).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) {
+		return User{}, ErrEmailInUse
+	}
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
-		return err
+		return User{}, err
+	}
+
+	// Remove a status left without a text and an emoji, unless one was set meanwhile.
+	if statusFields && user.Status != nil && user.Status.Text == "" && user.Status.Emoji == "" {
+		filter := bson.M{"_id": id, "status.text": bson.M{"$exists": false}, "status.emoji": bson.M{"$exists": false}}
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"status": ""}})
+		if err != nil {
+			return User{}, err
+		}
+		if result.ModifiedCount > 0 {
+			user.Status = nil
+		}
+	}
+
+	return user, nil
+}
+
+// RevokeUserTokens invalidates the tokens issued to the user before the given time,
+// or only the old-format ones if legacyOnly is set. A later revocation time that
+// is already stored is kept.
+func (client *MongoDBClient) RevokeUserTokens(ctx context.Context, id primitive.ObjectID, at time.Time,
+	legacyOnly bool) (err error) {
+	ctx, end := client.startOperation(ctx, "RevokeUserTokens", "users")
+	defer func() { err = end(err) }()
+
+	// Get the users collection from the database.
+	collection := client.Database(client.DBName).Collection("users")
+	if collection == nil {
+		return fmt.Errorf("database is nil")
 	}
 
-	// Define the filter and update operation for the update query.
-	filter := bson.M{"_id": originalID}
-	update := bson.M{
-		"$set": bson.M{
-			"name":       name,
-			"email":      email,
-			"password":   password,
-			"updated_at": time.Now(),
-		},
+	field := "tokens_revoked_at"
+	if legacyOnly {
+		field = "legacy_tokens_revoked_at"
 	}
 
-	// Execute the update query.
-	_, err = collection.UpdateOne(context.Background(), filter, update)
+	// Only move the revocation time forward.
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$max": bson.M{field: at}})
 	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
 
 	return nil
 }
 
-// DeleteUser deletes the user with the given ID from the MongoDB database.
-func (client *MongoDBClient) DeleteUser(id string) error {
+// Empty reports whether the update changes no field.
+func (u UserUpdate) Empty() bool {
+	return len(u.fields()) == 0
+}
+
+// fields returns the changed fields by their stored name. Cleared fields map to nil.
+func (u UserUpdate) fields() map[string]interface{} {
+	fields := u.ProfileUpdate.fields()
+	account := map[string]*string{"name": u.Name, "email": u.Email, "password": u.Password}
+	for field, value := range account {
+		if value != nil {
+			fields[field] = *value
+		}
+	}
+	return fields
+}
+
+// fields returns the changed profile fields by their stored name. Cleared fields
+// map to nil.
+func (u ProfileUpdate) fields() map[string]interface{} {
+	fields := map[string]interface{}{}
+	texts := map[string]*string{
+		"display_name":    u.DisplayName,
+		"avatar_url":      u.AvatarURL,
+		"avatar_image_id": u.AvatarImageID,
+		"bio":             u.Bio,
+		"timezone":        u.Timezone,
+		"locale":          u.Locale,
+	}
+	for field, value := range texts {
+		if value == nil {
+			continue
+		}
+		if *value == "" {
+			fields[field] = nil
+		} else {
+			fields[field] = *value
+		}
+	}
+	if u.Status != nil {
+		if u.Status.clears() {
+			fields["status"] = nil
+			return fields
+		}
+		for field, value := range map[string]*string{"status.text": u.Status.Text, "status.emoji": u.Status.Emoji} {
+			if value == nil {
+				continue
+			}
+			if *value == "" {
+				fields[field] = nil
+			} else {
+				fields[field] = *value
+			}
+		}
+		if u.Status.ExpiresAt != nil {
+			if u.Status.ExpiresAt.IsZero() {
+				fields["status.expires_at"] = nil
+			} else {
+				fields["status.expires_at"] = *u.Status.ExpiresAt
+			}
+		}
+	}
+	return fields
+}
+
+// DeleteUser deletes the user with the given ID from the MongoDB database, removes
+// them from every conversation and tombstones the messages they sent.
+func (client *MongoDBClient) DeleteUser(ctx context.Context, id string) (err error) {
+	ctx, end := client.startOperation(ctx, "DeleteUser", "users")
+	defer func() { err = end(err) }()
+
 	// Get the users collection from the database.
 	collection := client.Database(client.DBName).Collection("users")
 	if collection == nil {
@@ -140,21 +394,35 @@ func (client *MongoDBClient) DeleteUser(id string) error {
 		return err
 	}
 
-	// Define the filter for the delete query.
-	filter := bson.M{"_id": originalID}
+	// Delete the user, remove them from their conversations and tombstone their
+	// messages together, so that no conversation refers to a missing user.
+	return client.WithTransaction(ctx, func(ctx context.Context) error {
+		// Execute the delete query.
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": originalID})
+		if err != nil {
+			return err
+		}
 
-	// Execute the delete query.
-	result, err := collection.DeleteOne(context.Background(), filter)
-	if err != nil {
-		return err
-	}
+		// Check if a user was deleted.
+		if result.DeletedCount == 0 {
+			return ErrUserNotFound
+		}
 
-	// Check if a user was deleted.
-	if result.DeletedCount == 0 {
-		return errors.New("no user found with the given ID")
-	}
+		// Remove the user from the members of every conversation.
+		now := time.Now()
+		_, err = client.Database(client.DBName).Collection("conversations").UpdateMany(ctx,
+			bson.M{"users": originalID},
+			bson.M{"$pull": bson.M{"users": originalID}, "$set": bson.M{"updated_at": now}})
+		if err != nil {
+			return err
+		}
 
-	return nil
+		// Keep the user's messages in the history but clear their content.
+		_, err = client.Database(client.DBName).Collection("messages").UpdateMany(ctx,
+			bson.M{"sender_id": originalID, "deleted_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"content": "", "deleted_at": now}})
+		return err
+	})
 }
 
 // GetDatabaseNAmeFromURL extracts the database name from the given MongoDB URL.
@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// RequestIDHeader is the header that carries the ID of a request between services.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	requestUserKey
+)
+
+// New creates a logger that writes JSON lines at or above the given level.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// ParseLevel converts a level name such as "info" or "debug" to a slog.Level.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// WithLogger returns a copy of the context that carries the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by the context, or the default logger
+// if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of the context that carries the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by the context, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// requestUser records the authenticated user of a request. It is shared by
+// pointer so that the access log, which wraps the whole request, can see a user
+// identified by middleware further down the chain.
+type requestUser struct {
+	id string
+}
+
+// WithRequestUser returns a copy of the context in which WithUser records the
+// authenticated user for the access log.
+func WithRequestUser(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestUserKey, &requestUser{})
+}
+
+// RequestUserFromContext returns the user recorded by WithUser, if any.
+func RequestUserFromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(requestUserKey).(*requestUser); ok {
+		return user.id
+	}
+	return ""
+}
+
+// WithUser records the authenticated user for the access log and returns a copy
+// of the context whose logger includes the user ID.
+func WithUser(ctx context.Context, userID string) context.Context {
+	if user, ok := ctx.Value(requestUserKey).(*requestUser); ok {
+		user.id = userID
+	}
+	return WithLogger(ctx, FromContext(ctx).With("user_id", userID))
+}
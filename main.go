@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"go-chat-application/config"
+	"go-chat-application/handlers"
 	"go-chat-application/internal/database"
+	"go-chat-application/logging"
+	"go-chat-application/metrics"
+	"go-chat-application/realtime"
 	"go-chat-application/routes"
+	"go-chat-application/tokenPackage"
+	"go-chat-application/tracing"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -21,88 +28,190 @@ func main() {
 	// Load environment variables from .env file
 	godotenv.Load()
 
-	// Get PORT environment variable
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// "migrate" manages the schema migrations instead of running the server
+	args := os.Args[1:]
+	var migrate *migrateCommand
+	if len(args) > 0 && args[0] == "migrate" {
+		cmd, rest, err := parseMigrateCommand(args[1:])
+		if err != nil {
+			exitWithMigrateUsage(err)
+		}
+		migrate, args = &cmd, rest
 	}
 
-	// Get DATABASE_URL environment variable
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
+	// Read the configuration from the configuration file, the environment and the
+	// flags. Migrations only need the database settings to be valid.
+	load := config.Load
+	if migrate != nil {
+		load = config.LoadDatabase
 	}
+	cfg, err := load(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Write structured JSON logs, also for the standard library logger
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logger := logging.New(os.Stderr, level)
+	slog.SetDefault(logger)
+
 	// Extract database name from the database URL
-	dbName, err := database.GetDatabaseNAmeFromURL(dbURL)
+	dbName, err := database.GetDatabaseNAmeFromURL(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatal("Could not parse the database url")
+		fatal(logger, "Could not parse the database url", err)
 	}
 
-	// Get JWT_SECRET environment variable
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// Run the migrate subcommand and exit
+	if migrate != nil {
+		if err := migrate.run(cfg, dbName); err != nil {
+			fatal(logger, "Could not migrate the database", err)
+		}
+		return
+	}
+
+	// Load the asymmetric signing keys if a keys directory is configured
+	var keys *tokenPackage.KeySet
+	if cfg.JwtKeysDir != "" {
+		keys, err = tokenPackage.LoadKeySet(cfg.JwtKeysDir, cfg.JwtSigningKeyID)
+		if err != nil {
+			fatal(logger, "Could not load the JWT signing keys", err)
+		}
+	}
+
+	// Export spans to the configured exporter and accept W3C trace context
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter: cfg.TracingExporter,
+		Endpoint: cfg.TracingOTLPEndpoint,
+		Insecure: cfg.TracingOTLPInsecure,
+	})
+	if err != nil {
+		fatal(logger, "Could not set up tracing", err)
 	}
 
+	// Collect the metrics served on /metrics
+	m := metrics.New()
+
 	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	// Ensure the context is cancelled to avoid leaking resources
-	defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DatabaseConnectTimeout)
 	// Connect to the MongoDB database
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbURL))
+	// Log every database command with the ID of the request that issued it and record its latency
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.DatabaseURL).
+		SetMonitor(database.NewCommandMonitor(m.ObserveDatabaseCommand)))
+	// Release the context as soon as the connection attempt is over
+	cancel()
 	// Check if connection to the MongoDB database was successful
 	if err != nil {
-		log.Fatal("Could not open a connection to the MongoDB database")
+		fatal(logger, "Could not open a connection to the MongoDB database", err)
 	}
 
 	// Create a MongoDB client
-	mongoClient := &database.MongoDBClient{Client: client, DBName: dbName}
-
-	// Set the database and JWT secret in the API configuration
-	config.ApiCfg.DB = mongoClient
-	config.ApiCfg.JwtSecret = jwtSecret
-
-	// Create new routers
-	r := chi.NewRouter()
-	r_api := chi.NewRouter()
-
-	// Setup CORS for the main router
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
-
-	// Setup CORS for the API router
-	r_api.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
-
-	// Mount the API router on the main router
-	r.Mount("/api", r_api)
-
-	// Setup the routes for the main and API routers
-	routes.SetupRoutes(r)
-	routes.SetupApiRoutes(r_api)
+	mongoClient := &database.MongoDBClient{Client: client, DBName: dbName, Timeouts: cfg.DatabaseTimeouts()}
+
+	// Create the indexes and apply the other pending schema changes
+	if cfg.MigrateOnStartup {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.MigrationTimeout)
+		_, err := mongoClient.MigrateUp(ctx)
+		cancel()
+		if err != nil {
+			fatal(logger, "Could not apply the database migrations", err)
+		}
+	}
+
+	// Keep issued tokens in MongoDB so revocations survive restarts
+	tokenStore := tokenPackage.NewMongoTokenStore(mongoClient.Database(dbName), cfg.DatabaseTimeout)
+	m.RegisterTokenStoreSize(tokenStore.Size)
+
+	// Start the real-time hub that pushes events to connected clients
+	hub := realtime.NewHub()
+	go hub.Run()
+
+	// Create the application server and its routes
+	tokens := tokenPackage.NewTokenService(tokenStore, keys, cfg.JwtSecret)
+	tokens.LegacyTokenCompatibility = cfg.LegacyTokenCompatibility
+	tokens.LegacyTokenMaxAge = cfg.LegacyTokenMaxAge
+	s := handlers.NewServer(cfg, mongoClient, tokens, hub, logger, m)
 
 	// Create a new HTTP server
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: r,
+		Addr:              cfg.Addr(),
+		Handler:           routes.NewRouter(s),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
 	}
 
+	// End the real-time streams once the server starts shutting down. Hijacked
+	// WebSocket connections are not tracked by the server, so the hub waits for them.
+	server.RegisterOnShutdown(hub.Close)
+
+	// Stop on SIGINT or SIGTERM
+	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Start the HTTP server
-	err = server.ListenAndServe()
-	// Check if the server started successfully
-	if err != nil {
-		log.Fatal(err)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	logger.Info("Listening", "addr", server.Addr)
+
+	// Wait until the server fails or a shutdown signal arrives
+	select {
+	case err := <-serverErr:
+		fatal(logger, "HTTP server failed", err)
+	case <-stopCtx.Done():
+	}
+	// A second signal terminates the process immediately
+	stop()
+
+	shutdown(s, server, client, shutdownTracing)
+}
+
+// shutdown fails the readiness check, stops accepting connections and drains
+// in-flight requests and open streams within the timeout, then disconnects from MongoDB.
+func shutdown(s *handlers.Server, server *http.Server, client *mongo.Client,
+	shutdownTracing func(context.Context) error) {
+	logger, hub := s.Logger, s.Hub
+
+	// Report the server as not ready and give load balancers time to notice
+	s.BeginShutdown()
+	if s.Config.ShutdownDelay > 0 {
+		logger.Info("Shutting down, serving while traffic is routed away", "delay", s.Config.ShutdownDelay.String())
+		time.Sleep(s.Config.ShutdownDelay)
+	}
+
+	logger.Info("Shutting down, waiting for requests and streams to finish", "timeout", s.Config.ShutdownTimeout.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+
+	// Stop listening and wait for in-flight requests, including event streams
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Error draining HTTP requests", "error", err)
+		server.Close()
 	}
+
+	// Flush the queued events and wait for the WebSocket connections to close
+	if err := hub.Shutdown(ctx); err != nil {
+		logger.Error("Error draining real-time streams", "error", err)
+	}
+
+	// Disconnect from MongoDB; Disconnect waits for in-use connections up to the deadline
+	if err := client.Disconnect(ctx); err != nil {
+		logger.Error("Error disconnecting from MongoDB", "error", err)
+	}
+
+	// Export the spans that are still buffered
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Error flushing spans", "error", err)
+	}
+
+	logger.Info("Shutdown complete")
+}
+
+// fatal logs the error and exits.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every application metric.
+const namespace = "chat"
+
+// Define the transports of real-time connections
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// Metrics holds the Prometheus collectors of one application instance. A nil
+// *Metrics is valid and records nothing, so tests can leave it out.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests     *prometheus.CounterVec
+	httpDuration     *prometheus.HistogramVec
+	logins           *prometheus.CounterVec
+	connections      *prometheus.GaugeVec
+	messagesSent     prometheus.Counter
+	databaseDuration *prometheus.HistogramVec
+}
+
+// New creates the application metrics on a fresh registry, together with the
+// standard Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Number of login attempts by result.",
+		}, []string{"result"}),
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "realtime_connections",
+			Help:      "Number of open real-time connections by transport.",
+		}, []string{"transport"}),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_sent_total",
+			Help:      "Number of chat messages sent.",
+		}),
+		databaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "database_command_duration_seconds",
+			Help:      "Latency of MongoDB commands by command name and outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"command", "outcome"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.logins,
+		m.connections,
+		m.messagesSent,
+		m.databaseDuration,
+	)
+
+	// Report both transports from the start so dashboards show zero rather than no data
+	m.connections.WithLabelValues(TransportWebSocket)
+	m.connections.WithLabelValues(TransportSSE)
+
+	return m
+}
+
+// Handler returns the HTTP handler that serves the metrics in the Prometheus format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a completed HTTP request. The route is the pattern
+// that matched, so that path parameters do not create a series per ID.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// LoginSucceeded counts a successful login.
+func (m *Metrics) LoginSucceeded() {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues("success").Inc()
+}
+
+// LoginFailed counts a login rejected because of an unknown email or a wrong password.
+func (m *Metrics) LoginFailed() {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues("failure").Inc()
+}
+
+// ConnectionOpened counts a real-time connection as open until the returned
+// function is called.
+func (m *Metrics) ConnectionOpened(transport string) (closed func()) {
+	if m == nil {
+		return func() {}
+	}
+	gauge := m.connections.WithLabelValues(transport)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// MessageSent counts a chat message that was stored and delivered.
+func (m *Metrics) MessageSent() {
+	if m == nil {
+		return
+	}
+	m.messagesSent.Inc()
+}
+
+// ObserveDatabaseCommand records the latency of a MongoDB command.
+func (m *Metrics) ObserveDatabaseCommand(command string, duration time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if failed {
+		outcome = "failure"
+	}
+	m.databaseDuration.WithLabelValues(command, outcome).Observe(duration.Seconds())
+}
+
+// RegisterTokenStoreSize reports the number of live tokens, as returned by size,
+// every time the metrics are scraped.
+func (m *Metrics) RegisterTokenStoreSize(size func(context.Context) (int64, error)) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "token_store_tokens",
+		Help:      "Number of unexpired tokens in the token store.",
+	}, func() float64 {
+		n, err := size(context.Background())
+		if err != nil {
+			slog.Warn("Error reading the token store size", "error", err)
+			return math.NaN()
+		}
+		return float64(n)
+	}))
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-chat-application/logging"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// maxRequestIDLength is the longest X-Request-ID accepted from a client.
+const maxRequestIDLength = 128
+
+// RequestID returns middleware that gives every request an ID. The ID is taken from
+// the X-Request-ID header when the client sends a usable one and generated otherwise.
+// It is echoed in the response and stored in the request context together with a
+// logger that includes it.
+func RequestID(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Use the client's request ID or generate a new one
+			requestID := r.Header.Get(logging.RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.NewString()
+			}
+			w.Header().Set(logging.RequestIDHeader, requestID)
+
+			// Store the request ID and a logger that includes it in the request context
+			ctx := logging.WithRequestID(r.Context(), requestID)
+			ctx = logging.WithLogger(ctx, logger.With("request_id", requestID))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validRequestID reports whether the request ID is short and only contains
+// printable ASCII characters, so it is safe to log and echo.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog is middleware that writes one log line per request with its status,
+// size, latency and the authenticated user, if any. It must run after RequestID
+// so that the line carries the request ID.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Wrap the response writer to capture the status and size, keeping
+		// support for flushing and hijacking used by the real-time streams
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		r = r.WithContext(logging.WithRequestUser(r.Context()))
+
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+		latency := time.Since(start)
+
+		status := responseStatus(ww, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", routePattern(r)),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if userID := logging.RequestUserFromContext(r.Context()); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+
+		// Server errors are logged at a higher level so they stand out
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// responseStatus returns the status code written to the wrapped response writer.
+// Handlers that write nothing respond with 200, and hijacked WebSocket connections
+// have written 101 directly to the connection.
+func responseStatus(ww chimiddleware.WrapResponseWriter, r *http.Request) int {
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			status = http.StatusSwitchingProtocols
+		}
+	}
+	return status
+}
+
+// routePattern returns the chi route pattern that matched the request, such as
+// /api/conversations/{id}, or an empty string if no route matched.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
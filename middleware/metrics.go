@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"go-chat-application/metrics"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// Instrument returns middleware that records the count and latency of every request
+// by method, route pattern and status code.
+func Instrument(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Wrap the response writer to capture the status
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			start := time.Now()
+			next.ServeHTTP(ww, r)
+
+			// Requests that match no route share one label so that scanners
+			// probing random paths do not create a series per path
+			route := routePattern(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			m.ObserveRequest(r.Method, route, responseStatus(ww, r), time.Since(start))
+		})
+	}
+}
@@ -1,14 +1,94 @@
 package middleware
 
 import (
-	"context"
-	"go-chat-application/config"
+	"errors"
+	"go-chat-application/auth"
+	"go-chat-application/handlers"
+	"go-chat-application/internal/database"
+	"go-chat-application/logging"
+	"go-chat-application/tokenPackage"
 	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func WithDB(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), config.ApiCfg.DB, config.ApiCfg.DB)
-		next.ServeHTTP(w, r.WithContext(ctx))
+// RequireAuth returns middleware that rejects requests without a valid, unrevoked
+// JWT token. The user the token was issued to is loaded once and stored in the
+// request context as an auth.Principal.
+func RequireAuth(tokens *tokenPackage.TokenService, users database.UserRepository) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Extract the JWT token from the request header
+			tokenString := tokenPackage.ExtractJWTTokenFromHeader(r)
+			if tokenString == "" {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "Missing JWT token")
+				return
+			}
+
+			// Parse and validate the JWT token
+			claims, err := tokens.ParseAndValidateJWTToken(r.Context(), tokenString)
+			if err != nil {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "Invalid JWT token")
+				return
+			}
+
+			// Look up the stored state of the token and reject revoked tokens
+			record, err := tokens.Store.Get(r.Context(), claims.ID)
+			if err != nil && !errors.Is(err, tokenPackage.ErrTokenNotFound) {
+				handlers.RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to check JWT token", err)
+				return
+			}
+			if record.Revoked {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "JWT token has been revoked")
+				return
+			}
+
+			// Load the user the token was issued to
+			userID, err := primitive.ObjectIDFromHex(claims.UserID)
+			if err != nil {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "Unable to get user ID from JWT token")
+				return
+			}
+			user, err := users.GetUserByID(r.Context(), userID)
+			if errors.Is(err, database.ErrUserNotFound) {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "User no longer exists")
+				return
+			}
+			if err != nil {
+				handlers.RespondWithDatabaseError(w, r, http.StatusInternalServerError, "Unable to get user", err)
+				return
+			}
+
+			// Reject tokens issued before the user revoked them, including tokens the store does not know
+			if auth.TokenRevoked(user, claims) {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized, "JWT token has been revoked")
+				return
+			}
+
+			// Store the principal in the request context and add the user to the logs
+			ctx := auth.WithPrincipal(r.Context(), &auth.Principal{
+				UserID: userID,
+				User:   user,
+				Claims: claims,
+			})
+			ctx = logging.WithUser(ctx, userID.Hex())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// RequireAccessToken returns RequireAuth middleware for endpoints that only accept JWT access tokens.
+func RequireAccessToken(tokens *tokenPackage.TokenService, users database.UserRepository) func(http.HandlerFunc) http.HandlerFunc {
+	requireAuth := RequireAuth(tokens, users)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return requireAuth(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := auth.PrincipalFromContext(r.Context())
+			if principal.Claims.TokenType != tokenPackage.AccessTokenType {
+				handlers.RespondWithError(w, r, http.StatusUnauthorized,
+					"Using JWT refresh token when JWT access token is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
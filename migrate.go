@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go-chat-application/config"
+	"go-chat-application/internal/database"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrateUsage describes the migrate subcommand.
+const migrateUsage = `usage: go-chat-application migrate [up | down [steps] | status] [flags]
+
+  up      apply every pending migration (default)
+  down    revert the last steps applied migrations (default 1)
+  status  list the migrations and whether they are applied
+
+The flags are the same as the server's, but only the database settings are
+required.`
+
+// migrateCommand is a parsed "migrate" subcommand.
+type migrateCommand struct {
+	action string
+	steps  int
+}
+
+// parseMigrateCommand parses the arguments following "migrate" and returns the
+// command and the remaining arguments, which hold the configuration flags.
+func parseMigrateCommand(args []string) (migrateCommand, []string, error) {
+	cmd := migrateCommand{action: "up", steps: 1}
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd.action, args = args[0], args[1:]
+	}
+
+	switch cmd.action {
+	case "up", "status":
+	case "down":
+		if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+			steps, err := strconv.Atoi(args[0])
+			if err != nil || steps < 1 {
+				return cmd, nil, fmt.Errorf("invalid number of steps %q", args[0])
+			}
+			cmd.steps, args = steps, args[1:]
+		}
+	default:
+		return cmd, nil, fmt.Errorf("unknown migrate action %q", cmd.action)
+	}
+
+	// Only flags may follow, as the flag parser would silently ignore anything else
+	if len(args) > 0 && (args[0] == "" || args[0][0] != '-') {
+		return cmd, nil, fmt.Errorf("unexpected argument %q", args[0])
+	}
+
+	return cmd, args, nil
+}
+
+// run connects to the database named dbName and executes the command against it.
+func (cmd migrateCommand) run(cfg config.Config, dbName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DatabaseConnectTimeout)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.DatabaseURL))
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.MigrationTimeout)
+	defer cancel()
+	return cmd.apply(ctx, &database.MongoDBClient{Client: client, DBName: dbName, Timeouts: cfg.DatabaseTimeouts()})
+}
+
+// apply executes the command against the database.
+func (cmd migrateCommand) apply(ctx context.Context, client *database.MongoDBClient) error {
+	switch cmd.action {
+	case "down":
+		reverted, err := client.MigrateDown(ctx, cmd.steps)
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migration to revert")
+		}
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %d: %s\n", migration.Version, migration.Description)
+		}
+	case "status":
+		statuses, err := client.MigrationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-28s  %s\n", status.Version, state, status.Description)
+		}
+	default:
+		applied, err := client.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied %d: %s\n", migration.Version, migration.Description)
+		}
+	}
+	return nil
+}
+
+// exitWithMigrateUsage reports a malformed migrate subcommand and exits.
+func exitWithMigrateUsage(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n\n%s\n", err, migrateUsage)
+	os.Exit(2)
+}
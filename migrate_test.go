@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMigrateCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		cmd  migrateCommand
+		rest []string
+	}{
+		{nil, migrateCommand{action: "up", steps: 1}, nil},
+		{[]string{"up"}, migrateCommand{action: "up", steps: 1}, []string{}},
+		{[]string{"-port", "80"}, migrateCommand{action: "up", steps: 1}, []string{"-port", "80"}},
+		{[]string{"status", "-database-url", "mongodb://db/chat"}, migrateCommand{action: "status", steps: 1},
+			[]string{"-database-url", "mongodb://db/chat"}},
+		{[]string{"down"}, migrateCommand{action: "down", steps: 1}, []string{}},
+		{[]string{"down", "3"}, migrateCommand{action: "down", steps: 3}, []string{}},
+		{[]string{"down", "2", "-config", "chat.yaml"}, migrateCommand{action: "down", steps: 2},
+			[]string{"-config", "chat.yaml"}},
+		{[]string{"down", "-config", "chat.yaml"}, migrateCommand{action: "down", steps: 1},
+			[]string{"-config", "chat.yaml"}},
+	}
+	for _, test := range tests {
+		cmd, rest, err := parseMigrateCommand(test.args)
+		if err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		if cmd != test.cmd || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("%q: got %+v and %q, want %+v and %q", test.args, cmd, rest, test.cmd, test.rest)
+		}
+	}
+
+	// Bad arguments are rejected
+	for _, args := range [][]string{
+		{"sideways"},
+		{"down", "0"},
+		{"down", "1", "2"},
+		{"down", "many"},
+		{"up", "2"},
+	} {
+		if cmd, _, err := parseMigrateCommand(args); err == nil {
+			t.Errorf("%q: got %+v, want an error", args, cmd)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-chat-application/realtime")
+
+// Define the event types pushed to connected clients
+const (
+	EventMessageCreated      = "message.created"
+	EventConversationCreated = "conversation.created"
+	EventConversationUpdated = "conversation.updated"
+	EventConversationDeleted = "conversation.deleted"
+	EventMemberAdded         = "conversation.member_added"
+	EventMemberRemoved       = "conversation.member_removed"
+	// EventResync tells the client that missed events could not all be replayed,
+	// so it must fetch its conversations and message history again.
+	EventResync = "resync"
+)
+
+// SendBufferSize is the number of pending events a subscriber may queue before
+// the hub considers it too slow and disconnects it.
+const SendBufferSize = 256
+
+// Event is a single notification delivered to the members of a conversation.
+// ID is only set for events that can be replayed from storage, such as new messages.
+type Event struct {
+	ID             string      `json:"id,omitempty"`
+	Type           string      `json:"type"`
+	ConversationID string      `json:"conversation_id"`
+	Data           interface{} `json:"data"`
+}
+
+// Envelope is an event together with its JSON encoding, shared by all recipients.
+type Envelope struct {
+	Event   Event
+	Payload []byte
+}
+
+// Subscriber is a single connection receiving events on behalf of a user.
+type Subscriber struct {
+	UserID string
+	send   chan *Envelope
+
+	// tracked reports whether the subscriber counts as an open stream of the hub
+	tracked  bool
+	released sync.Once
+}
+
+// Messages returns the channel of events for the subscriber. The channel is
+// closed when the subscriber is unregistered or dropped by the hub.
+func (s *Subscriber) Messages() <-chan *Envelope {
+	return s.send
+}
+
+// delivery is an encoded event addressed to a set of users. spanContext is the
+// span of the Publish call, which becomes the parent of the fan-out span.
+type delivery struct {
+	userIDs     []string
+	envelope    *Envelope
+	spanContext trace.SpanContext
+}
+
+// Hub keeps track of every connected subscriber and fans out events to them.
+type Hub struct {
+	subscribers map[string]map[*Subscriber]bool
+	register    chan *Subscriber
+	unregister  chan *Subscriber
+	broadcast   chan delivery
+
+	// quit asks Run to stop, and done is closed once it has
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// streams counts the subscribers whose connections are still open
+	mu      sync.Mutex
+	closed  bool
+	streams sync.WaitGroup
+}
+
+// NewHub creates a new hub. Run must be called for it to start delivering events.
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[string]map[*Subscriber]bool),
+		register:    make(chan *Subscriber),
+		unregister:  make(chan *Subscriber),
+		broadcast:   make(chan delivery, SendBufferSize),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Run processes registrations and deliveries until the hub is closed.
+func (h *Hub) Run() {
+	defer close(h.done)
+
+	for {
+		select {
+		case s := <-h.register:
+			// Add the subscriber to the set of connections for its user
+			if h.subscribers[s.UserID] == nil {
+				h.subscribers[s.UserID] = make(map[*Subscriber]bool)
+			}
+			h.subscribers[s.UserID][s] = true
+
+		case s := <-h.unregister:
+			h.remove(s)
+
+		case d := <-h.broadcast:
+			h.deliver(d)
+
+		case <-h.quit:
+			// Deliver the events that are already queued before disconnecting everyone,
+			// so that subscribers receive them ahead of the end of their stream
+		drain:
+			for {
+				select {
+				case d := <-h.broadcast:
+					h.deliver(d)
+				default:
+					break drain
+				}
+			}
+			for _, connections := range h.subscribers {
+				for s := range connections {
+					h.remove(s)
+				}
+			}
+			return
+		}
+	}
+}
+
+// deliver queues the payload on every connection of every recipient.
+func (h *Hub) deliver(d delivery) {
+	_, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), d.spanContext), "Hub.deliver",
+		trace.WithAttributes(attribute.String("realtime.event_type", d.envelope.Event.Type)))
+	defer span.End()
+
+	delivered, dropped := 0, 0
+	for _, userID := range d.userIDs {
+		for s := range h.subscribers[userID] {
+			select {
+			case s.send <- d.envelope:
+				delivered++
+			default:
+				// The subscriber's buffer is full, so drop it rather than stall the hub
+				h.remove(s)
+				dropped++
+			}
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("realtime.connections_delivered", delivered),
+		attribute.Int("realtime.connections_dropped", dropped),
+	)
+}
+
+// remove deletes the subscriber from the hub and closes its channel.
+func (h *Hub) remove(s *Subscriber) {
+	connections, ok := h.subscribers[s.UserID]
+	if !ok || !connections[s] {
+		return
+	}
+	delete(connections, s)
+	if len(connections) == 0 {
+		delete(h.subscribers, s.UserID)
+	}
+	close(s.send)
+}
+
+// Subscribe registers a new subscriber for the given user. Once the hub has been
+// closed the returned subscriber's channel is already closed.
+func (h *Hub) Subscribe(userID string) *Subscriber {
+	s := &Subscriber{UserID: userID, send: make(chan *Envelope, SendBufferSize)}
+
+	// Count the subscriber as an open stream unless the hub is shutting down
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(s.send)
+		return s
+	}
+	s.tracked = true
+	h.streams.Add(1)
+	h.mu.Unlock()
+
+	select {
+	case h.register <- s:
+	case <-h.done:
+		close(s.send)
+	}
+	return s
+}
+
+// Unsubscribe removes the subscriber from the hub and marks its stream as finished.
+// It is safe to call more than once.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	s.released.Do(func() {
+		select {
+		case h.unregister <- s:
+		case <-h.done:
+		}
+		if s.tracked {
+			h.streams.Done()
+		}
+	})
+}
+
+// Close stops the hub from accepting new subscribers, delivers the events that are
+// already queued and then closes every subscriber's channel, which ends its stream.
+// It returns without waiting for the streams to finish; use Shutdown for that.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+
+	h.closeOnce.Do(func() { close(h.quit) })
+}
+
+// Shutdown closes the hub and waits until every stream has written its pending
+// events and finished, or until the context is done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.Close()
+
+	// Wait for Run to flush the queued events and disconnect the subscribers
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Wait for the connections to drain their buffers and close
+	idle := make(chan struct{})
+	go func() {
+		h.streams.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish delivers the event to every connection of the given users.
+func (h *Hub) Publish(ctx context.Context, userIDs []string, event Event) {
+	// A nil hub means real-time delivery is disabled
+	if h == nil || len(userIDs) == 0 {
+		return
+	}
+
+	ctx, span := tracer.Start(ctx, "Hub.Publish", trace.WithAttributes(
+		attribute.String("realtime.event_type", event.Type),
+		attribute.Int("realtime.recipients", len(userIDs)),
+	))
+	defer span.End()
+
+	// Encode the event once for all recipients
+	envelope, err := NewEnvelope(event)
+	if err != nil {
+		slog.Error("Error marshalling event", "type", event.Type, "error", err)
+		return
+	}
+
+	// Events published after the hub has stopped have no one left to receive them
+	select {
+	case h.broadcast <- delivery{userIDs: userIDs, envelope: envelope, spanContext: span.SpanContext()}:
+	case <-h.done:
+	}
+}
+
+// expiryTimer returns a channel that receives a value once expiresAt has passed,
+// and a function to stop the timer. A zero expiresAt never expires.
+func expiryTimer(expiresAt time.Time) (<-chan time.Time, func()) {
+	if expiresAt.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(expiresAt))
+	return timer.C, func() { timer.Stop() }
+}
+
+// NewEnvelope encodes the event as JSON.
+func NewEnvelope(event Event) (*Envelope, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Event: event, Payload: payload}, nil
+}
@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// keepAlivePeriod is how often a comment line is sent to stop proxies from
+// closing an idle event stream.
+const keepAlivePeriod = 30 * time.Second
+
+// ServeSSE streams hub events for the given user as Server-Sent Events until the
+// client disconnects. The replay events are written first, followed by live
+// events; live events that carry an ID no greater than the last replayed ID are
+// skipped so that nothing is delivered twice. The stream ends once expiresAt has
+// passed, so that the client reconnects with fresh credentials.
+func ServeSSE(hub *Hub, w http.ResponseWriter, r *http.Request, s *Subscriber, replay []Event,
+	expiresAt time.Time) {
+	defer hub.Unsubscribe(s)
+
+	// Streaming requires the response writer to support flushing
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream outlives the server's write timeout, so lift the deadline
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Set the headers for an event stream and disable proxy buffering
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Write the missed events first
+	lastID := ""
+	for _, event := range replay {
+		envelope, err := NewEnvelope(event)
+		if err != nil {
+			continue
+		}
+		if err := writeSSE(w, envelope); err != nil {
+			return
+		}
+		lastID = event.ID
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlivePeriod)
+	defer ticker.Stop()
+	expired, stopExpiry := expiryTimer(expiresAt)
+	defer stopExpiry()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// The client went away
+			return
+
+		case <-expired:
+			// The credentials the stream was opened with are no longer valid
+			return
+
+		case envelope, ok := <-s.Messages():
+			if !ok {
+				// The hub dropped the subscriber or is shutting down
+				return
+			}
+			// ObjectID hex strings sort in roughly creation order, so they can be compared directly
+			if envelope.Event.ID != "" && envelope.Event.ID <= lastID {
+				continue
+			}
+			if err := writeSSE(w, envelope); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes a single event in the text/event-stream format.
+func writeSSE(w http.ResponseWriter, envelope *Envelope) error {
+	if envelope.Event.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", envelope.Event.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", envelope.Event.Type, envelope.Payload)
+	return err
+}
@@ -0,0 +1,143 @@
+package realtime
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-chat-application/logging"
+
+	"github.com/gorilla/websocket"
+)
+
+// Define the WebSocket keepalive and size limits
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+	// Send pings to the peer with this period, which must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// Maximum message size allowed from the peer
+	maxMessageSize = 512
+)
+
+// ServeWS upgrades the HTTP connection to a WebSocket and streams hub events
+// for the given user until the connection is closed or expiresAt has passed.
+// Browsers may only connect from the allowed origins, which are patterns like
+// the CORS allowed origins.
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, userID string, allowedOrigins []string,
+	expiresAt time.Time) {
+	// CORS does not apply to WebSocket handshakes, so the origin is checked here
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return originAllowed(r.Header.Get("Origin"), allowedOrigins)
+		},
+	}
+
+	// Upgrade the connection; the upgrader writes the error response itself
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Error upgrading WebSocket connection", "error", err)
+		return
+	}
+
+	// Register the connection with the hub and start pumping messages
+	s := hub.Subscribe(userID)
+	go writePump(conn, s, expiresAt)
+	readPump(hub, conn, s)
+}
+
+// originAllowed reports whether the origin matches one of the allowed patterns.
+// A pattern is "*", an exact origin or an origin with one "*" wildcard, such as
+// "https://*.example.com". Requests without an origin do not come from a browser
+// and are allowed.
+func originAllowed(origin string, allowedOrigins []string) bool {
+	if origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowedOrigins {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(pattern, "*"); ok &&
+			len(origin) >= len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPump reads from the connection so that control frames are processed, and
+// unregisters the subscriber once the peer goes away.
+func readPump(hub *Hub, conn *websocket.Conn, s *Subscriber) {
+	defer func() {
+		hub.Unsubscribe(s)
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	// Clients only receive events, so anything they send is discarded
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Warn("WebSocket read error", "user_id", s.UserID, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// writePump writes queued events and periodic pings to the connection, and closes
+// it once expiresAt has passed.
+func writePump(conn *websocket.Conn, s *Subscriber, expiresAt time.Time) {
+	ticker := time.NewTicker(pingPeriod)
+	expired, stopExpiry := expiryTimer(expiresAt)
+	defer func() {
+		ticker.Stop()
+		stopExpiry()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case envelope, ok := <-s.Messages():
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel, either because the subscriber fell behind
+				// or because the server is shutting down
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, envelope.Payload); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case <-expired:
+			// The credentials the connection was opened with are no longer valid
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"))
+			return
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package routes_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// refresh exchanges the refresh token and returns the new access and refresh tokens.
+func (api *testAPI) refresh(refreshToken string) (string, string) {
+	api.t.Helper()
+
+	w := api.expect(http.StatusOK, "POST", "/api/users/refresh", refreshToken, nil)
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(api.t, w, &response)
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		api.t.Fatalf("refresh did not return both tokens: %s", w.Body.String())
+	}
+	return response.AccessToken, response.RefreshToken
+}
+
+// legacyToken signs a token with the custom claim names used before typed claims,
+// issued to the user a minute ago.
+func legacyToken(t *testing.T, userID, issuer string) string {
+	t.Helper()
+
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ID":        uuid.NewString(),
+		"Issuer":    issuer,
+		"Subject":   `ObjectID("` + userID + `")`,
+		"IssuedAt":  jwt.NewNumericDate(now.Add(-time.Minute)),
+		"ExpiresAt": jwt.NewNumericDate(now.Add(time.Hour)),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("signing legacy token: %v", err)
+	}
+	return token
+}
+
+func TestLogin(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+
+	api.expect(http.StatusOK, "GET", "/api/users/me", alice.AccessToken, nil)
+
+	// Refresh tokens are not accepted in place of access tokens
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", alice.RefreshToken, nil)
+
+	// Wrong credentials are rejected
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/login", "",
+		map[string]string{"email": alice.Email, "password": "wrong"})
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/login", "",
+		map[string]string{"email": "nobody@example.com", "password": alice.Password})
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+
+	accessToken, refreshToken := api.refresh(alice.RefreshToken)
+	if refreshToken == alice.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	api.expect(http.StatusOK, "GET", "/api/users/me", accessToken, nil)
+
+	// Access tokens cannot be exchanged
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", accessToken, nil)
+
+	// The replacement can be exchanged in turn
+	accessToken, refreshToken = api.refresh(refreshToken)
+
+	// Reusing a rotated refresh token revokes the whole session
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", alice.RefreshToken, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", refreshToken, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", accessToken, nil)
+}
+
+func TestConcurrentRefreshesOnlyRotateOnce(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+
+	// Exchange the same refresh token several times at once
+	const attempts = 8
+	statuses := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = api.do("POST", "/api/users/refresh", alice.RefreshToken, nil).Code
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusUnauthorized:
+		default:
+			t.Fatalf("got status %d, want %d or %d", status, http.StatusOK, http.StatusUnauthorized)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d refreshes succeeded, want 1", succeeded)
+	}
+}
+
+func TestLogoutRevokesTheSession(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	otherSession := api.login(alice.Email, alice.Password)
+
+	api.expect(http.StatusOK, "POST", "/api/users/logout", alice.AccessToken, nil)
+
+	// Both tokens of the session are revoked
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", alice.AccessToken, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", alice.RefreshToken, nil)
+
+	// Other sessions are left alone
+	api.expect(http.StatusOK, "GET", "/api/users/me", otherSession.AccessToken, nil)
+	api.refresh(otherSession.RefreshToken)
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	otherSession := api.login(alice.Email, alice.Password)
+	bob := api.createUser("bob", "bob@example.com")
+
+	api.expect(http.StatusOK, "POST", "/api/users/logout-all", alice.AccessToken, nil)
+
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", alice.AccessToken, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", otherSession.AccessToken, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", otherSession.RefreshToken, nil)
+
+	// Other users are left alone
+	api.expect(http.StatusOK, "GET", "/api/users/me", bob.AccessToken, nil)
+}
+
+func TestLogoutAllRevokesLegacyTokens(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	accessToken := legacyToken(t, alice.ID, "go-chat-application-access")
+	refreshToken := legacyToken(t, alice.ID, "go-chat-application-refresh")
+
+	// Old-format tokens are accepted until they are revoked
+	api.expect(http.StatusOK, "GET", "/api/users/me", accessToken, nil)
+
+	api.expect(http.StatusOK, "POST", "/api/users/logout-all", alice.AccessToken, nil)
+
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", accessToken, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", refreshToken, nil)
+
+	// Logging in again right away works
+	session := api.login(alice.Email, alice.Password)
+	api.expect(http.StatusOK, "GET", "/api/users/me", session.AccessToken, nil)
+	api.refresh(session.RefreshToken)
+}
+
+func TestLogoutRevokesLegacyTokens(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	accessToken := legacyToken(t, alice.ID, "go-chat-application-access")
+	refreshToken := legacyToken(t, alice.ID, "go-chat-application-refresh")
+
+	// Old-format tokens have no session, so logging out of one revokes them all
+	api.expect(http.StatusOK, "POST", "/api/users/logout", accessToken, nil)
+
+	api.expect(http.StatusUnauthorized, "GET", "/api/users/me", accessToken, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/users/refresh", refreshToken, nil)
+
+	// Sessions of current tokens are left alone
+	api.expect(http.StatusOK, "GET", "/api/users/me", alice.AccessToken, nil)
+}
@@ -0,0 +1,113 @@
+package routes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createConversation creates a conversation of the owner with the members and returns its ID.
+func (api *testAPI) createConversation(owner testUser, name string, members ...testUser) string {
+	api.t.Helper()
+
+	memberIDs := []string{}
+	for _, member := range members {
+		memberIDs = append(memberIDs, member.ID)
+	}
+	w := api.expect(http.StatusCreated, "POST", "/api/conversations", owner.AccessToken,
+		map[string]interface{}{"name": name, "users": memberIDs})
+
+	var conversation struct {
+		ID    string   `json:"_id"`
+		Users []string `json:"users"`
+	}
+	decode(api.t, w, &conversation)
+	if len(conversation.Users) != len(members)+1 {
+		api.t.Fatalf("got %d members, want %d", len(conversation.Users), len(members)+1)
+	}
+	return conversation.ID
+}
+
+func TestConversationMembership(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	bob := api.createUser("bob", "bob@example.com")
+	carol := api.createUser("carol", "carol@example.com")
+
+	id := api.createConversation(alice, "Lunch", bob)
+	path := "/api/conversations/" + id
+	message := map[string]string{"content": "Hello"}
+
+	// Members can read the conversation and send messages
+	api.expect(http.StatusOK, "GET", path, bob.AccessToken, nil)
+	api.expect(http.StatusCreated, "POST", path+"/messages", bob.AccessToken, message)
+	api.expect(http.StatusOK, "GET", path+"/messages", alice.AccessToken, nil)
+
+	// Other users cannot
+	api.expect(http.StatusForbidden, "GET", path, carol.AccessToken, nil)
+	api.expect(http.StatusForbidden, "GET", path+"/messages", carol.AccessToken, nil)
+	api.expect(http.StatusForbidden, "POST", path+"/messages", carol.AccessToken, message)
+	api.expect(http.StatusForbidden, "POST", path+"/users", carol.AccessToken,
+		map[string]string{"user_id": carol.ID})
+
+	// Conversations only list the caller's own
+	w := api.expect(http.StatusOK, "GET", "/api/conversations", carol.AccessToken, nil)
+	var conversations []map[string]interface{}
+	decode(t, w, &conversations)
+	if len(conversations) != 0 {
+		t.Fatalf("carol sees %d conversations, want 0", len(conversations))
+	}
+
+	// Members can add users, who then have access
+	api.expect(http.StatusOK, "POST", path+"/users", bob.AccessToken, map[string]string{"user_id": carol.ID})
+	api.expect(http.StatusOK, "GET", path, carol.AccessToken, nil)
+
+	// Removed members lose access
+	api.expect(http.StatusOK, "DELETE", path+"/users/"+bob.ID, alice.AccessToken, nil)
+	api.expect(http.StatusForbidden, "GET", path, bob.AccessToken, nil)
+	api.expect(http.StatusForbidden, "POST", path+"/messages", bob.AccessToken, message)
+
+	// Deleted conversations are gone for everyone
+	api.expect(http.StatusOK, "DELETE", path, alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "GET", path, alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "GET", path+"/messages", carol.AccessToken, nil)
+}
+
+func TestConversationErrors(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	unknownID := primitive.NewObjectID().Hex()
+
+	// Members must exist
+	api.expect(http.StatusBadRequest, "POST", "/api/conversations", alice.AccessToken,
+		map[string]interface{}{"name": "Lunch", "users": []string{unknownID}})
+	api.expect(http.StatusBadRequest, "POST", "/api/conversations", alice.AccessToken,
+		map[string]interface{}{"name": "Lunch", "users": []string{"not-an-id"}})
+	api.expect(http.StatusBadRequest, "POST", "/api/conversations", alice.AccessToken,
+		map[string]interface{}{"name": " "})
+
+	id := api.createConversation(alice, "Lunch")
+	api.expect(http.StatusNotFound, "POST", "/api/conversations/"+id+"/users", alice.AccessToken,
+		map[string]string{"user_id": unknownID})
+
+	// Unknown conversations are not found
+	api.expect(http.StatusNotFound, "GET", "/api/conversations/"+unknownID, alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "GET", "/api/conversations/not-an-id", alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "POST", "/api/conversations/"+unknownID+"/messages", alice.AccessToken,
+		map[string]string{"content": "Hello"})
+	api.expect(http.StatusNotFound, "PUT", "/api/conversations/"+unknownID, alice.AccessToken,
+		map[string]string{"name": "Dinner"})
+	api.expect(http.StatusNotFound, "DELETE", "/api/conversations/"+unknownID, alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "DELETE", "/api/conversations/"+unknownID+"/users/"+alice.ID, alice.AccessToken, nil)
+
+	// Neither are conversations once deleted
+	api.expect(http.StatusOK, "DELETE", "/api/conversations/"+id, alice.AccessToken, nil)
+	api.expect(http.StatusNotFound, "PUT", "/api/conversations/"+id, alice.AccessToken,
+		map[string]string{"name": "Dinner"})
+	api.expect(http.StatusNotFound, "DELETE", "/api/conversations/"+id, alice.AccessToken, nil)
+
+	// Requests without a valid access token are rejected
+	api.expect(http.StatusUnauthorized, "GET", "/api/conversations", "", nil)
+	api.expect(http.StatusUnauthorized, "GET", "/api/conversations", "not-a-token", nil)
+}
@@ -0,0 +1,58 @@
+package routes_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-chat-application/tokenPackage"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWKS(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	current := &tokenPackage.Key{ID: "current", Method: jwt.SigningMethodEdDSA, PrivateKey: private, PublicKey: public}
+	retired := &tokenPackage.Key{ID: "retired", Method: jwt.SigningMethodEdDSA, PublicKey: public}
+	keys := &tokenPackage.KeySet{
+		Signing:      current,
+		Verification: map[string]*tokenPackage.Key{"current": current, "retired": retired},
+	}
+	api := newCustomTestAPI(t, nil, keys)
+
+	// The verification keys are published without authentication and can be cached
+	w := api.expect(http.StatusOK, "GET", "/.well-known/jwks.json", "", nil)
+	if cache := w.Header().Get("Cache-Control"); !strings.Contains(cache, "max-age") {
+		t.Errorf("got Cache-Control %q, want a max-age", cache)
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	decode(t, w, &jwks)
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2: %s", len(jwks.Keys), w.Body.String())
+	}
+	for i, id := range []string{"current", "retired"} {
+		key := jwks.Keys[i]
+		if key["kid"] != id || key["kty"] != "OKP" || key["crv"] != "Ed25519" || key["alg"] != "EdDSA" ||
+			key["use"] != "sig" || key["x"] == "" || key["d"] != "" {
+			t.Errorf("unexpected key %v", key)
+		}
+	}
+
+	// Tokens are signed with the current key
+	alice := api.createUser("alice", "alice@example.com")
+	token, _, err := jwt.NewParser().ParseUnverified(alice.AccessToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	if token.Header["kid"] != "current" {
+		t.Errorf("got kid %v, want current", token.Header["kid"])
+	}
+	api.expect(http.StatusOK, "GET", "/api/users/me", alice.AccessToken, nil)
+}
@@ -0,0 +1,105 @@
+package routes_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// messagePage is the body of a page of the message history
+type messagePage struct {
+	Messages []struct {
+		Content string `json:"content"`
+	} `json:"messages"`
+	HasMore bool `json:"has_more"`
+}
+
+// sendMessage sends a message to the conversation and returns its ID.
+func (api *testAPI) sendMessage(sender testUser, conversationID, content string) string {
+	api.t.Helper()
+
+	w := api.expect(http.StatusCreated, "POST", "/api/conversations/"+conversationID+"/messages",
+		sender.AccessToken, map[string]string{"content": content})
+
+	var message struct {
+		ID string `json:"_id"`
+	}
+	decode(api.t, w, &message)
+	return message.ID
+}
+
+// getMessages fetches a page of the message history and returns its contents in order.
+func (api *testAPI) getMessages(token, path string) ([]string, messagePage) {
+	api.t.Helper()
+
+	var page messagePage
+	decode(api.t, api.expect(http.StatusOK, "GET", path, token, nil), &page)
+
+	contents := []string{}
+	for _, message := range page.Messages {
+		contents = append(contents, message.Content)
+	}
+	return contents, page
+}
+
+func TestMessagePages(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	bob := api.createUser("bob", "bob@example.com")
+
+	id := api.createConversation(alice, "Lunch", bob)
+	path := "/api/conversations/" + id + "/messages"
+	ids := []string{}
+	for i := 0; i < 105; i++ {
+		ids = append(ids, api.sendMessage(alice, id, strconv.Itoa(i)))
+	}
+
+	tests := []struct {
+		query   string
+		first   string
+		last    string
+		count   int
+		hasMore bool
+	}{
+		// Without a cursor, the latest messages are returned oldest first
+		{"?limit=3", "102", "104", 3, true},
+		{"?before=" + ids[3] + "&limit=2", "1", "2", 2, true},
+		{"?before=" + ids[2] + "&limit=2", "0", "1", 2, false},
+		{"?after=" + ids[100] + "&limit=2", "101", "102", 2, true},
+		{"?after=" + ids[101] + "&limit=3", "102", "104", 3, false},
+		// Larger pages are capped at the maximum
+		{"?limit=1000", "5", "104", 100, true},
+		{"?after=" + ids[0] + "&limit=1000", "1", "100", 100, true},
+	}
+	for _, test := range tests {
+		contents, page := api.getMessages(bob.AccessToken, path+test.query)
+		if len(contents) == 0 {
+			t.Fatalf("GET %s: got no messages", test.query)
+		}
+		if len(contents) != test.count || contents[0] != test.first || contents[len(contents)-1] != test.last {
+			t.Errorf("GET %s: got %d messages from %v to %v, want %d from %s to %s", test.query, len(contents),
+				contents[0], contents[len(contents)-1], test.count, test.first, test.last)
+		}
+		if page.HasMore != test.hasMore {
+			t.Errorf("GET %s: got has_more %v, want %v", test.query, page.HasMore, test.hasMore)
+		}
+	}
+
+	// The default page holds the latest 50 messages
+	contents, page := api.getMessages(bob.AccessToken, path)
+	if len(contents) != 50 || contents[0] != "55" || !page.HasMore {
+		t.Errorf("got %d messages from %s with has_more %v, want 50 from 55", len(contents), contents[0], page.HasMore)
+	}
+
+	// Cursors must be message IDs, and only one may be given
+	api.expect(http.StatusBadRequest, "GET", path+"?before=not-an-id", bob.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", path+"?after=not-an-id", bob.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", path+"?before="+ids[1]+"&after="+ids[0], bob.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", path+"?limit=0", bob.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", path+"?limit=many", bob.AccessToken, nil)
+
+	// Only members may send messages
+	carol := api.createUser("carol", "carol@example.com")
+	api.expect(http.StatusForbidden, "POST", path, carol.AccessToken, map[string]string{"content": "Hi"})
+	api.expect(http.StatusForbidden, "GET", path, carol.AccessToken, nil)
+}
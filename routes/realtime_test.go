@@ -0,0 +1,149 @@
+package routes_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-chat-application/handlers"
+	"go-chat-application/realtime"
+)
+
+// sseEvent is an event read from an event stream
+type sseEvent struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// eventStream is an open event stream of a test server.
+type eventStream struct {
+	t      *testing.T
+	reader *bufio.Reader
+	cancel context.CancelFunc
+}
+
+// openEvents opens the event stream of the caller on the server, resuming from the
+// last event ID if it is set. It returns the response when the stream is refused.
+func openEvents(t *testing.T, server *httptest.Server, token, lastEventID string) (*eventStream, *http.Response) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		r.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	response, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("opening event stream: %v", err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK {
+		return nil, response
+	}
+	return &eventStream{t: t, reader: bufio.NewReader(response.Body), cancel: cancel}, response
+}
+
+// next reads the next event of the stream, skipping comments.
+func (s *eventStream) next() sseEvent {
+	s.t.Helper()
+
+	// Close the stream rather than hang if the event never comes
+	timer := time.AfterFunc(5*time.Second, s.cancel)
+	defer timer.Stop()
+
+	event := sseEvent{}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.Type != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventStreamReplay(t *testing.T) {
+	hub := realtime.NewHub()
+	go hub.Run()
+	t.Cleanup(hub.Close)
+
+	api := newCustomTestAPI(t, hub, nil)
+	alice := api.createUser("alice", "alice@example.com")
+	bob := api.createUser("bob", "bob@example.com")
+	id := api.createConversation(alice, "Lunch", bob)
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, api.sendMessage(alice, id, strconv.Itoa(i)))
+	}
+
+	server := httptest.NewServer(api.handler)
+	t.Cleanup(server.Close)
+
+	// The messages missed since the last event are replayed, then live ones follow
+	stream, response := openEvents(t, server, bob.AccessToken, ids[0])
+	if stream == nil {
+		t.Fatalf("got status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	for _, want := range ids[1:] {
+		if event := stream.next(); event.ID != want || event.Type != realtime.EventMessageCreated {
+			t.Fatalf("got event %+v, want message %s", event, want)
+		}
+	}
+	live := api.sendMessage(alice, id, "live")
+	if event := stream.next(); event.ID != live || !strings.Contains(event.Data, `"live"`) {
+		t.Fatalf("got event %+v, want message %s", event, live)
+	}
+
+	// As many missed messages as can be replayed are replayed
+	batch := []string{}
+	for i := 0; i < handlers.MaxReplayedEvents; i++ {
+		batch = append(batch, api.sendMessage(alice, id, strconv.Itoa(i)))
+	}
+	stream, _ = openEvents(t, server, bob.AccessToken, live)
+	for _, want := range []string{batch[0], batch[1]} {
+		if event := stream.next(); event.ID != want {
+			t.Fatalf("got event %+v, want message %s", event, want)
+		}
+	}
+
+	// A client that missed more is told to fetch the history again
+	stream, _ = openEvents(t, server, bob.AccessToken, ids[len(ids)-1])
+	if event := stream.next(); event.Type != realtime.EventResync || event.ID != "" {
+		t.Fatalf("got event %+v, want a resync", event)
+	}
+
+	// The last event ID must be a message ID
+	if _, response := openEvents(t, server, bob.AccessToken, "not-an-id"); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d for a malformed Last-Event-ID, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestStreamsWithoutHub(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+
+	// A server built without a hub cannot stream live events
+	api.expect(http.StatusServiceUnavailable, "GET", "/api/ws", alice.AccessToken, nil)
+	api.expect(http.StatusServiceUnavailable, "GET", "/api/events", alice.AccessToken, nil)
+}
@@ -2,20 +2,86 @@ package routes
 
 import (
 	"go-chat-application/handlers"
+	"go-chat-application/logging"
 	"go-chat-application/middleware"
+	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/cors"
 )
 
-func SetupRoutes(r chi.Router) {
-	r.Get("/readiness", handlers.ReadinessHandler)
-	r.Get("/err", handlers.ErrorHandler)
+// NewRouter builds the HTTP router for the given server, with the API mounted under /api.
+func NewRouter(s *handlers.Server) http.Handler {
+	// Create new routers
+	r := chi.NewRouter()
+	r_api := chi.NewRouter()
+
+	// Tag every request with an ID, trace it and log it once it completes
+	r.Use(middleware.RequestID(s.Logger))
+	r.Use(middleware.Trace)
+	r.Use(middleware.AccessLog)
+	r.Use(middleware.Instrument(s.Metrics))
+
+	// Setup CORS for the main and API routers
+	r.Use(corsHandler(s.Config.CORSAllowedOrigins))
+	r_api.Use(corsHandler(s.Config.CORSAllowedOrigins))
+
+	// Mount the API router on the main router
+	r.Mount("/api", r_api)
+
+	// Setup the routes for the main and API routers
+	SetupRoutes(r, s)
+	SetupApiRoutes(r_api, s)
+
+	return r
+}
+
+func corsHandler(allowedOrigins []string) func(http.Handler) http.Handler {
+	return cors.Handler(cors.Options{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Last-Event-ID",
+			logging.RequestIDHeader, "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"Link", handlers.TotalCountHeader, logging.RequestIDHeader},
+		AllowCredentials: false,
+		MaxAge:           300,
+	})
 }
 
-func SetupApiRoutes(r chi.Router) {
-	r.Post("/users/login", middleware.WithDB(handlers.LoginUserHandler))
-	r.Post("/users/create", middleware.WithDB(handlers.CreateUserHandler))
-	r.Get("/users", middleware.WithDB(handlers.GetUsersHandler))
-	r.Put("/users", middleware.WithDB(handlers.UpdateUserHandler))
-	r.Delete("/users", middleware.WithDB(handlers.DeleteUserHandler))
+func SetupRoutes(r chi.Router, s *handlers.Server) {
+	r.Get("/healthz", s.HealthzHandler)
+	r.Get("/readiness", s.ReadinessHandler)
+	r.Handle("/metrics", s.Metrics.Handler())
+	r.Get("/err", s.ErrorHandler)
+	r.Get("/.well-known/jwks.json", s.JWKSHandler)
+}
+
+func SetupApiRoutes(r chi.Router, s *handlers.Server) {
+	requireAccess := middleware.RequireAccessToken(s.Tokens, s.Users)
+
+	r.Post("/users/login", s.LoginUserHandler)
+	r.Post("/users/refresh", s.RefreshTokenHandler)
+	r.Post("/users/logout", requireAccess(s.LogoutUserHandler))
+	r.Post("/users/logout-all", requireAccess(s.LogoutAllUserHandler))
+	r.Post("/users/create", s.CreateUserHandler)
+	r.Get("/users", requireAccess(s.GetUsersHandler))
+	r.Get("/users/me", requireAccess(s.GetCurrentUserHandler))
+	r.Patch("/users/me", requireAccess(s.PatchCurrentUserHandler))
+	r.Get("/users/{id}", requireAccess(s.GetUserHandler))
+	r.Put("/users", requireAccess(s.UpdateUserHandler))
+	r.Delete("/users", requireAccess(s.DeleteUserHandler))
+
+	r.Post("/conversations", requireAccess(s.CreateConversationHandler))
+	r.Get("/conversations", requireAccess(s.GetConversationsHandler))
+	r.Get("/conversations/{id}", requireAccess(s.GetConversationHandler))
+	r.Put("/conversations/{id}", requireAccess(s.RenameConversationHandler))
+	r.Delete("/conversations/{id}", requireAccess(s.DeleteConversationHandler))
+	r.Post("/conversations/{id}/users", requireAccess(s.AddConversationUserHandler))
+	r.Delete("/conversations/{id}/users/{userID}", requireAccess(s.RemoveConversationUserHandler))
+
+	r.Post("/conversations/{id}/messages", requireAccess(s.SendMessageHandler))
+	r.Get("/conversations/{id}/messages", requireAccess(s.GetMessagesHandler))
+
+	r.Get("/ws", requireAccess(s.WebSocketHandler))
+	r.Get("/events", requireAccess(s.EventStreamHandler))
 }
@@ -0,0 +1,139 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-chat-application/config"
+	"go-chat-application/handlers"
+	"go-chat-application/internal/database"
+	"go-chat-application/realtime"
+	"go-chat-application/routes"
+	"go-chat-application/tokenPackage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testSecret signs the tokens of the test servers.
+const testSecret = "test-secret"
+
+// testAPI is the router of a server backed by the in-memory repository and token store.
+type testAPI struct {
+	t       *testing.T
+	handler http.Handler
+}
+
+// newTestAPI creates a router with the default configuration, except for the
+// cheapest bcrypt cost so that the tests stay fast. The server has no hub.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	return newCustomTestAPI(t, nil, nil)
+}
+
+// newCustomTestAPI creates a router like newTestAPI whose server publishes live
+// events to the hub and signs tokens with the keys, if any.
+func newCustomTestAPI(t *testing.T, hub *realtime.Hub, keys *tokenPackage.KeySet) *testAPI {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.JwtSecret = testSecret
+	cfg.BcryptCost = bcrypt.MinCost
+
+	tokens := tokenPackage.NewTokenService(tokenPackage.NewMemoryTokenStore(), keys, cfg.JwtSecret)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := handlers.NewServer(cfg, database.NewMemoryDB(), tokens, hub, logger, nil)
+
+	return &testAPI{t: t, handler: routes.NewRouter(s)}
+}
+
+// do sends a request with the token as bearer token, if any, and the body encoded
+// as JSON, if any.
+func (api *testAPI) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	return w
+}
+
+// expect sends a request and fails the test unless it responds with the status.
+func (api *testAPI) expect(status int, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	w := api.do(method, path, token, body)
+	if w.Code != status {
+		api.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, w.Code, status, w.Body.String())
+	}
+	return w
+}
+
+// testUser is a registered user and the tokens of one of their sessions.
+type testUser struct {
+	ID           string
+	Email        string
+	Password     string
+	AccessToken  string
+	RefreshToken string
+}
+
+// createUser registers a user and logs them in.
+func (api *testAPI) createUser(name, email string) testUser {
+	api.t.Helper()
+
+	user := testUser{Email: email, Password: "password-of-" + name}
+	api.expect(http.StatusCreated, "POST", "/api/users/create", "",
+		map[string]string{"name": name, "email": email, "password": user.Password})
+
+	session := api.login(user.Email, user.Password)
+	user.ID, user.AccessToken, user.RefreshToken = session.ID, session.AccessToken, session.RefreshToken
+	return user
+}
+
+// login starts a new session for the user.
+func (api *testAPI) login(email, password string) testUser {
+	api.t.Helper()
+
+	w := api.expect(http.StatusOK, "POST", "/api/users/login", "",
+		map[string]string{"email": email, "password": password})
+
+	var response struct {
+		ID           string `json:"id"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(api.t, w, &response)
+	return testUser{ID: response.ID, Email: email, Password: password,
+		AccessToken: response.AccessToken, RefreshToken: response.RefreshToken}
+}
+
+// decode decodes the JSON body of the response.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
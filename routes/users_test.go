@@ -0,0 +1,205 @@
+package routes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go-chat-application/handlers"
+)
+
+// nextLinkPattern extracts the URL of the next page from a Link header.
+var nextLinkPattern = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+// listUsers follows the pages of the user directory from the path and returns
+// the names of the users in order.
+func (api *testAPI) listUsers(token, path string) []string {
+	api.t.Helper()
+
+	names := []string{}
+	for pages := 0; path != ""; pages++ {
+		if pages > 10 {
+			api.t.Fatal("too many pages")
+		}
+		w := api.expect(http.StatusOK, "GET", path, token, nil)
+
+		var users []map[string]interface{}
+		decode(api.t, w, &users)
+		for _, user := range users {
+			if _, ok := user["email"]; ok {
+				api.t.Fatalf("the directory shows the email of %v", user["name"])
+			}
+			names = append(names, user["name"].(string))
+		}
+
+		path = ""
+		if link := w.Header().Get("Link"); link != "" {
+			match := nextLinkPattern.FindStringSubmatch(link)
+			if match == nil {
+				api.t.Fatalf("invalid Link header %q", link)
+			}
+			path = match[1]
+		}
+	}
+	return names
+}
+
+func TestUserDirectoryPages(t *testing.T) {
+	api := newTestAPI(t)
+	dave := api.createUser("dave", "dave@example.com")
+	users := map[string]testUser{}
+	for _, name := range []string{"bob", "erin", "alice", "carol"} {
+		users[name] = api.createUser(name, name+"@example.com")
+	}
+	api.expectPatch(http.StatusOK, users["bob"].AccessToken, `{"display_name": "The Builder"}`)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/users?limit=2", "alice bob carol dave erin"},
+		{"/api/users?limit=2&sort=-name", "erin dave carol bob alice"},
+		{"/api/users?limit=3&sort=created_at", "dave bob erin alice carol"},
+		{"/api/users?limit=1&sort=-created_at", "carol alice erin bob dave"},
+		{"/api/users?q=CA", "carol"},
+		{"/api/users?q=e&limit=1", "erin"},
+		{"/api/users?q=the", "bob"},
+		{"/api/users?q=Carol@Example.com", "carol"},
+		// Emails only match in full, so that hidden emails cannot be guessed
+		{"/api/users?q=carol@", ""},
+		{"/api/users?q=example.com", ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(api.listUsers(dave.AccessToken, test.path), " "); got != test.want {
+			t.Errorf("GET %s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+
+	// The total counts every matching user
+	w := api.expect(http.StatusOK, "GET", "/api/users?limit=2", dave.AccessToken, nil)
+	if total := w.Header().Get(handlers.TotalCountHeader); total != "5" {
+		t.Errorf("got total %q, want 5", total)
+	}
+
+	// A cursor only works with the sort order it was made for
+	link := nextLinkPattern.FindStringSubmatch(w.Header().Get("Link"))
+	if link == nil {
+		t.Fatalf("missing Link header")
+	}
+	api.expect(http.StatusBadRequest, "GET", link[1]+"&sort=created_at", dave.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", "/api/users?cursor=invalid", dave.AccessToken, nil)
+	api.expect(http.StatusBadRequest, "GET", "/api/users?limit=0", dave.AccessToken, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/api/users", "", nil)
+}
+
+// patchMe sends a merge patch of the caller and returns the response.
+func (api *testAPI) patchMe(token, patch string) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	r := httptest.NewRequest("PATCH", "/api/users/me", strings.NewReader(patch))
+	r.Header.Set("Content-Type", handlers.MergePatchContentType)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	return w
+}
+
+// expectPatch sends a merge patch of the caller, fails the test unless it responds
+// with the status, and returns the decoded body.
+func (api *testAPI) expectPatch(status int, token, patch string) map[string]interface{} {
+	api.t.Helper()
+
+	w := api.patchMe(token, patch)
+	if w.Code != status {
+		api.t.Fatalf("PATCH %s: got status %d, want %d: %s", patch, w.Code, status, w.Body.String())
+	}
+	var body map[string]interface{}
+	decode(api.t, w, &body)
+	return body
+}
+
+func TestPatchCurrentUser(t *testing.T) {
+	api := newTestAPI(t)
+	alice := api.createUser("alice", "alice@example.com")
+	api.createUser("bob", "bob@example.com")
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	// Only the fields in the patch change
+	user := api.expectPatch(http.StatusOK, alice.AccessToken,
+		`{"display_name": "Alice", "bio": "Hi", "status": {"text": "Lunch", "emoji": "🍕", "expires_at": "`+expiresAt+`"}}`)
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"bio": "Hello"}`)
+	if user["display_name"] != "Alice" || user["bio"] != "Hello" || user["name"] != "alice" {
+		t.Fatalf("unexpected user after patch: %v", user)
+	}
+
+	// Null removes a field, and the status is merged field by field
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"display_name": null, "status": {"emoji": null}}`)
+	if _, ok := user["display_name"]; ok {
+		t.Errorf("display_name was not removed: %v", user)
+	}
+	status, _ := user["status"].(map[string]interface{})
+	if status["text"] != "Lunch" || status["emoji"] != nil || status["expires_at"] == nil {
+		t.Errorf("unexpected status after merge: %v", user["status"])
+	}
+
+	// A status left without a text and an emoji is cleared
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"status": {"text": null, "emoji": null}}`)
+	if _, ok := user["status"]; ok {
+		t.Errorf("status was not cleared: %v", user["status"])
+	}
+
+	// So is a null status, and a new status does not inherit from the old one
+	api.expectPatch(http.StatusOK, alice.AccessToken, `{"status": {"text": "Away", "expires_at": "`+expiresAt+`"}}`)
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"status": null}`)
+	if _, ok := user["status"]; ok {
+		t.Errorf("status was not cleared: %v", user["status"])
+	}
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"status": {"emoji": "🌴"}}`)
+	status, _ = user["status"].(map[string]interface{})
+	if status["emoji"] != "🌴" || status["text"] != nil || status["expires_at"] != nil {
+		t.Errorf("unexpected new status: %v", user["status"])
+	}
+	user = api.expectPatch(http.StatusOK, alice.AccessToken, `{"status": null}`)
+
+	// The changes are stored
+	w := api.expect(http.StatusOK, "GET", "/api/users/me", alice.AccessToken, nil)
+	user = nil
+	decode(t, w, &user)
+	if _, ok := user["status"]; ok || user["bio"] != "Hello" {
+		t.Errorf("unexpected stored user: %v", user)
+	}
+
+	// Invalid fields are all reported at once
+	body := api.expectPatch(http.StatusBadRequest, alice.AccessToken, `{"name": null, "timezone": "Nowhere", "color": "red"}`)
+	fields, _ := body["fields"].(map[string]interface{})
+	for _, field := range []string{"name", "timezone", "color"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("field %s is not reported as invalid: %v", field, body)
+		}
+	}
+	api.expectPatch(http.StatusBadRequest, alice.AccessToken, `["name"]`)
+
+	// Changing the email requires the current password and a free address
+	api.expectPatch(http.StatusBadRequest, alice.AccessToken, `{"email": "alice@example.org"}`)
+	api.expectPatch(http.StatusForbidden, alice.AccessToken, `{"email": "alice@example.org", "current_password": "wrong"}`)
+	api.expectPatch(http.StatusConflict, alice.AccessToken,
+		`{"email": "bob@example.com", "current_password": "`+alice.Password+`"}`)
+	user = api.expectPatch(http.StatusOK, alice.AccessToken,
+		`{"email": "alice@example.org", "current_password": "`+alice.Password+`"}`)
+	if user["email"] != "alice@example.org" {
+		t.Errorf("email was not changed: %v", user)
+	}
+
+	// Patches must be sent as JSON
+	r := httptest.NewRequest("PATCH", "/api/users/me", strings.NewReader(`{"bio": "Hi"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Authorization", "Bearer "+alice.AccessToken)
+	w = httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got status %d for a text patch, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,132 @@
+package tokenPackage
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Define the issuer and audience of every token issued by this server
+const Issuer = "go-chat-application"
+const Audience = "go-chat-application"
+
+// Define the token types that distinguish access and refresh tokens
+const AccessTokenType = "access"
+const RefreshTokenType = "refresh"
+
+// ErrLegacyTokenTooOld is returned for a legacy token issued before the end of the
+// compatibility window.
+var ErrLegacyTokenTooOld = errors.New("legacy token is past the compatibility window")
+
+// Define the issuers used by tokens created before typed claims were introduced
+const legacyAccessIssuer = "go-chat-application-access"
+const legacyRefreshIssuer = "go-chat-application-refresh"
+
+// Claims are the claims carried by every token issued by this server.
+type Claims struct {
+	jwt.RegisteredClaims
+	TokenType string `json:"token_type"`
+	SessionID string `json:"sid"`
+	UserID    string `json:"uid"`
+
+	// legacy is set on the claims of tokens issued with the old custom claim names
+	legacy bool
+}
+
+// Legacy reports whether the token was issued with the old custom claim names.
+func (c *Claims) Legacy() bool {
+	return c.legacy
+}
+
+// NewClaims creates the claims for a new token of the given type.
+func NewClaims(tokenType, userID, sessionID string, expiration time.Duration) (*Claims, error) {
+	// Generate a UUID for the token
+	tokenID, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			Issuer:    Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+		},
+		TokenType: tokenType,
+		SessionID: sessionID,
+		UserID:    userID,
+	}, nil
+}
+
+// Validate checks the claims that golang-jwt does not know about. It is called by
+// the parser after the registered claims have been validated.
+func (c *Claims) Validate() error {
+	if c.TokenType != AccessTokenType && c.TokenType != RefreshTokenType {
+		return errors.New("invalid token type")
+	}
+	if c.UserID == "" || c.UserID != c.Subject {
+		return errors.New("invalid user ID")
+	}
+	return nil
+}
+
+// claimsFromLegacy converts the claims of a token issued before typed claims were
+// introduced. It returns an error if the token is not a valid, unexpired legacy
+// token issued after notIssuedBefore.
+func claimsFromLegacy(mapClaims jwt.MapClaims, notIssuedBefore time.Time) (*Claims, error) {
+	// Map the old issuers onto token types
+	issuer, _ := mapClaims["Issuer"].(string)
+	tokenType := ""
+	switch issuer {
+	case legacyAccessIssuer:
+		tokenType = AccessTokenType
+	case legacyRefreshIssuer:
+		tokenType = RefreshTokenType
+	default:
+		return nil, errors.New("invalid token issuer")
+	}
+
+	// The old ExpiresAt claim was never enforced by golang-jwt, so check it here
+	expiresAt, ok := mapClaims["ExpiresAt"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(expiresAt), 0)) {
+		return nil, jwt.ErrTokenExpired
+	}
+
+	// Legacy tokens are only accepted for a while after they were issued
+	issuedAt, ok := mapClaims["IssuedAt"].(float64)
+	if !ok || time.Unix(int64(issuedAt), 0).Before(notIssuedBefore) {
+		return nil, ErrLegacyTokenTooOld
+	}
+
+	// Remove the "ObjectID(" and ")" parts from the old Subject claim
+	userID, _ := mapClaims["Subject"].(string)
+	userID = strings.TrimPrefix(userID, "ObjectID(\"")
+	userID = strings.TrimSuffix(userID, "\")")
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{Audience},
+			ExpiresAt: jwt.NewNumericDate(time.Unix(int64(expiresAt), 0)),
+			IssuedAt:  jwt.NewNumericDate(time.Unix(int64(issuedAt), 0)),
+		},
+		TokenType: tokenType,
+		UserID:    userID,
+		legacy:    true,
+	}
+	claims.ID, _ = mapClaims["ID"].(string)
+	claims.SessionID, _ = mapClaims["SessionID"].(string)
+
+	if err := claims.Validate(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
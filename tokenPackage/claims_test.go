@@ -0,0 +1,91 @@
+package tokenPackage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// signLegacyToken signs the claims of a token in the format used before typed claims.
+func signLegacyToken(t *testing.T, secret, issuer, userID string, issuedAt, expiresAt time.Time) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"ID":        "legacy-token",
+		"Issuer":    issuer,
+		"Subject":   `ObjectID("` + userID + `")`,
+		"IssuedAt":  issuedAt.Unix(),
+		"ExpiresAt": expiresAt.Unix(),
+	})
+	tokenString, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("signing legacy token: %v", err)
+	}
+	return tokenString
+}
+
+func TestParseLegacyTokens(t *testing.T) {
+	const secret = "test-secret"
+	userID := primitive.NewObjectID().Hex()
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		issuer    string
+		issuedAt  time.Time
+		expiresAt time.Time
+		tokenType string
+		wantErr   string
+	}{
+		{"access", legacyAccessIssuer, now.Add(-time.Minute), now.Add(time.Hour), AccessTokenType, ""},
+		{"refresh", legacyRefreshIssuer, now.Add(-time.Hour), now.Add(24 * time.Hour), RefreshTokenType, ""},
+		{"expired", legacyAccessIssuer, now.Add(-2 * time.Hour), now.Add(-time.Hour), "", jwt.ErrTokenExpired.Error()},
+		{"wrong issuer", "someone-else", now.Add(-time.Minute), now.Add(time.Hour), "", "invalid token issuer"},
+		{"past the cutoff", legacyRefreshIssuer, now.Add(-8 * 24 * time.Hour), now.Add(time.Hour), "", ErrLegacyTokenTooOld.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewTokenService(NewMemoryTokenStore(), nil, secret)
+			tokenString := signLegacyToken(t, secret, test.issuer, userID, test.issuedAt, test.expiresAt)
+
+			claims, err := s.ParseAndValidateJWTToken(context.Background(), tokenString)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing legacy token: %v", err)
+			}
+			if !claims.Legacy() || claims.TokenType != test.tokenType || claims.UserID != userID ||
+				claims.IssuedAt == nil || claims.IssuedAt.Unix() != test.issuedAt.Unix() {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestLegacyTokensCanBeDisabled(t *testing.T) {
+	const secret = "test-secret"
+	now := time.Now()
+	tokenString := signLegacyToken(t, secret, legacyAccessIssuer, primitive.NewObjectID().Hex(),
+		now.Add(-time.Minute), now.Add(time.Hour))
+
+	s := NewTokenService(NewMemoryTokenStore(), nil, secret)
+	s.LegacyTokenCompatibility = false
+	if _, err := s.ParseAndValidateJWTToken(context.Background(), tokenString); err == nil {
+		t.Error("legacy token accepted with compatibility disabled")
+	}
+
+	// A shorter maximum age closes the window earlier
+	s = NewTokenService(NewMemoryTokenStore(), nil, secret)
+	s.LegacyTokenMaxAge = time.Second
+	if _, err := s.ParseAndValidateJWTToken(context.Background(), tokenString); !errors.Is(err, ErrLegacyTokenTooOld) {
+		t.Errorf("got error %v, want %v", err, ErrLegacyTokenTooOld)
+	}
+}
@@ -0,0 +1,153 @@
+package tokenPackage
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is an asymmetric key used to sign or verify tokens. PrivateKey is nil for
+// keys that are only kept to verify tokens signed before a rotation.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens and every key that tokens may be verified with.
+type KeySet struct {
+	Signing      *Key
+	Verification map[string]*Key
+}
+
+// LoadKeySet loads every "<kid>.pem" file in the given directory. Each file holds
+// an RSA or Ed25519 private key, or the public key of a retired key that is still
+// accepted for verification. The private key named signingKeyID signs new tokens.
+func LoadKeySet(dir, signingKeyID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem files found in %s", dir)
+	}
+
+	keySet := &KeySet{Verification: make(map[string]*Key)}
+	for _, path := range paths {
+		// The key ID is the file name without its extension
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keySet.Verification[id] = key
+	}
+
+	// Pick the signing key
+	signing, ok := keySet.Verification[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKeyID, dir)
+	}
+	if signing.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingKeyID)
+	}
+	keySet.Signing = signing
+
+	return keySet, nil
+}
+
+// parseKey decodes a PEM encoded private or public key.
+func parseKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// JWK is the JSON Web Key representation of a public verification key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set, sorted by key ID.
+func (ks *KeySet) JWKS() map[string][]JWK {
+	keys := []JWK{}
+	if ks != nil {
+		for _, key := range ks.Verification {
+			jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+			switch k := key.PublicKey.(type) {
+			case *rsa.PublicKey:
+				jwk.KeyType = "RSA"
+				jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+				jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+			case ed25519.PublicKey:
+				jwk.KeyType = "OKP"
+				jwk.Curve = "Ed25519"
+				jwk.X = base64.RawURLEncoding.EncodeToString(k)
+			}
+			keys = append(keys, jwk)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+
+	return map[string][]JWK{"keys": keys}
+}
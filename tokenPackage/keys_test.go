@@ -0,0 +1,202 @@
+package tokenPackage
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writePEM writes the private key, or only its public key if public is set, to
+// "<id>.pem" in the directory.
+func writePEM(t *testing.T, dir, id string, key crypto.Signer, public bool) {
+	t.Helper()
+
+	block := &pem.Block{Type: "PRIVATE KEY"}
+	var err error
+	if public {
+		block.Type = "PUBLIC KEY"
+		block.Bytes, err = x509.MarshalPKIXPublicKey(key.Public())
+	} else {
+		block.Bytes, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatalf("encoding key %s: %v", id, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("writing key %s: %v", id, err)
+	}
+}
+
+// issueAccessToken issues an access token with the token service.
+func issueAccessToken(t *testing.T, s *TokenService) string {
+	t.Helper()
+
+	token, _, err := s.IssueToken(context.Background(), AccessTokenType, "user", "session", time.Hour)
+	if err != nil {
+		t.Fatalf("issuing token: %v", err)
+	}
+	return token
+}
+
+// tokenHeader returns the key ID and algorithm in the header of a token.
+func tokenHeader(t *testing.T, tokenString string) (string, string) {
+	t.Helper()
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid, token.Method.Alg()
+}
+
+func TestKeyRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+
+	// Sign with an RSA key
+	dir := t.TempDir()
+	writePEM(t, dir, "2024-01", rsaKey, false)
+	keys, err := LoadKeySet(dir, "2024-01")
+	if err != nil {
+		t.Fatalf("loading keys: %v", err)
+	}
+	before := NewTokenService(NewMemoryTokenStore(), keys, "")
+	oldToken := issueAccessToken(t, before)
+	if kid, alg := tokenHeader(t, oldToken); kid != "2024-01" || alg != "RS256" {
+		t.Errorf("got kid %q and alg %q, want 2024-01 and RS256", kid, alg)
+	}
+
+	// Rotate to an Ed25519 key, keeping only the public part of the RSA key
+	writePEM(t, dir, "2024-01", rsaKey, true)
+	writePEM(t, dir, "2024-02", edKey, false)
+	keys, err = LoadKeySet(dir, "2024-02")
+	if err != nil {
+		t.Fatalf("loading rotated keys: %v", err)
+	}
+	after := NewTokenService(before.Store, keys, "")
+	newToken := issueAccessToken(t, after)
+	if kid, alg := tokenHeader(t, newToken); kid != "2024-02" || alg != "EdDSA" {
+		t.Errorf("got kid %q and alg %q, want 2024-02 and EdDSA", kid, alg)
+	}
+
+	// Tokens signed with either key are accepted
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := after.ParseAndValidateJWTToken(context.Background(), token); err != nil {
+			t.Errorf("validating token: %v", err)
+		}
+	}
+
+	// Tokens signed with a key that was removed are not
+	if err := os.Remove(filepath.Join(dir, "2024-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = LoadKeySet(dir, "2024-02")
+	if err != nil {
+		t.Fatalf("loading keys: %v", err)
+	}
+	removed := NewTokenService(before.Store, keys, "")
+	if _, err := removed.ParseAndValidateJWTToken(context.Background(), oldToken); err == nil {
+		t.Error("token signed with a removed key accepted")
+	}
+
+	// Neither are HMAC tokens once keys are used
+	hmacToken := issueAccessToken(t, NewTokenService(before.Store, nil, "secret"))
+	if _, err := after.ParseAndValidateJWTToken(context.Background(), hmacToken); err == nil {
+		t.Error("HMAC token accepted without a secret")
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	dir := t.TempDir()
+	writePEM(t, dir, "retired", key, true)
+
+	tests := []struct {
+		name         string
+		dir          string
+		signingKeyID string
+	}{
+		{"no keys", t.TempDir(), "current"},
+		{"unknown signing key", dir, "current"},
+		{"public signing key", dir, "retired"},
+	}
+	for _, test := range tests {
+		if _, err := LoadKeySet(test.dir, test.signingKeyID); err == nil {
+			t.Errorf("%s: loaded a key set", test.name)
+		}
+	}
+
+	// Files that are not keys are rejected
+	if err := os.WriteFile(filepath.Join(dir, "current.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeySet(dir, "current"); err == nil {
+		t.Error("loaded a key set with an invalid file")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating Ed25519 key: %v", err)
+	}
+	dir := t.TempDir()
+	writePEM(t, dir, "b-rsa", rsaKey, true)
+	writePEM(t, dir, "a-ed25519", edKey, false)
+	keys, err := LoadKeySet(dir, "a-ed25519")
+	if err != nil {
+		t.Fatalf("loading keys: %v", err)
+	}
+
+	// Every verification key is published, sorted by ID, without private parts
+	want := []JWK{
+		{KeyType: "OKP", KeyID: "a-ed25519", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(edPublic)},
+		{KeyType: "RSA", KeyID: "b-rsa", Use: "sig", Algorithm: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}
+	got := keys.JWKS()["keys"]
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got key %+v, want %+v", got[i], want[i])
+		}
+	}
+	if want[1].E != "AQAB" {
+		t.Errorf("got exponent %q, want AQAB", want[1].E)
+	}
+
+	// Without keys the set is empty
+	if keys := (*KeySet)(nil).JWKS()["keys"]; keys == nil || len(keys) != 0 {
+		t.Errorf("got %v for no keys, want an empty list", keys)
+	}
+}
@@ -0,0 +1,145 @@
+package tokenPackage
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryTokenStore is a TokenStore that keeps tokens in a map guarded by a mutex.
+// Its contents are lost when the process exits.
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]TokenRecord
+	// expiries orders the stored tokens by expiry so that expired ones are dropped
+	// without scanning the whole map.
+	expiries expiryHeap
+}
+
+// tokenExpiry is the expiry of a stored token.
+type tokenExpiry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// expiryHeap is a min-heap of token expiries, soonest first.
+type expiryHeap []tokenExpiry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(tokenExpiry)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// NewMemoryTokenStore creates an empty in-memory token store.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]TokenRecord)}
+}
+
+func (s *MemoryTokenStore) Add(ctx context.Context, record TokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired tokens so the map does not grow without bound
+	now := time.Now()
+	for len(s.expiries) > 0 && now.After(s.expiries[0].expiresAt) {
+		expired := heap.Pop(&s.expiries).(tokenExpiry)
+		delete(s.tokens, expired.id)
+	}
+
+	if _, ok := s.tokens[record.ID]; !ok {
+		s.tokens[record.ID] = record
+		heap.Push(&s.expiries, tokenExpiry{id: record.ID, expiresAt: record.ExpiresAt})
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(ctx context.Context, id string) (TokenRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.tokens[id]
+	if !ok {
+		return TokenRecord{}, ErrTokenNotFound
+	}
+	return record, nil
+}
+
+func (s *MemoryTokenStore) Revoke(ctx context.Context, id, replacedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	record.Revoked = true
+	if replacedBy != "" {
+		record.ReplacedBy = replacedBy
+	}
+	s.tokens[id] = record
+	return nil
+}
+
+func (s *MemoryTokenStore) RevokeIfActive(ctx context.Context, id, replacedBy string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.tokens[id]
+	if !ok {
+		return false, ErrTokenNotFound
+	}
+	if record.Revoked {
+		return false, nil
+	}
+	record.Revoked = true
+	if replacedBy != "" {
+		record.ReplacedBy = replacedBy
+	}
+	s.tokens[id] = record
+	return true, nil
+}
+
+func (s *MemoryTokenStore) RevokeSession(ctx context.Context, sessionID string) error {
+	return s.revokeWhere(func(record TokenRecord) bool { return record.SessionID == sessionID })
+}
+
+func (s *MemoryTokenStore) RevokeSubject(ctx context.Context, subject string) error {
+	return s.revokeWhere(func(record TokenRecord) bool { return record.Subject == subject })
+}
+
+// Size counts the tokens that have not expired, whether or not expired ones have
+// been dropped yet.
+func (s *MemoryTokenStore) Size(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var size int64
+	for _, record := range s.tokens {
+		if !now.After(record.ExpiresAt) {
+			size++
+		}
+	}
+	return size, nil
+}
+
+// revokeWhere revokes every stored token matching the predicate.
+func (s *MemoryTokenStore) revokeWhere(match func(TokenRecord) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, record := range s.tokens {
+		if match(record) {
+			record.Revoked = true
+			s.tokens[id] = record
+		}
+	}
+	return nil
+}
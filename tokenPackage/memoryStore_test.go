@@ -0,0 +1,58 @@
+package tokenPackage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	now := time.Now()
+
+	// Store tokens out of expiry order, some of them already expired
+	expiries := []time.Duration{time.Hour, -time.Minute, 2 * time.Hour, -time.Hour, time.Minute}
+	for i, expiry := range expiries {
+		record := TokenRecord{ID: strconv.Itoa(i), ExpiresAt: now.Add(expiry)}
+		if err := store.Add(ctx, record); err != nil {
+			t.Fatalf("adding token %d: %v", i, err)
+		}
+	}
+
+	// Expired tokens are not counted, even before they are dropped
+	if size, _ := store.Size(ctx); size != 3 {
+		t.Errorf("got size %d, want 3", size)
+	}
+
+	// The next insert drops the expired tokens and keeps the others
+	if err := store.Add(ctx, TokenRecord{ID: "new", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("adding token: %v", err)
+	}
+	for _, id := range []string{"0", "2", "4", "new"} {
+		if _, err := store.Get(ctx, id); err != nil {
+			t.Errorf("token %s: %v", id, err)
+		}
+	}
+	for _, id := range []string{"1", "3"} {
+		if _, err := store.Get(ctx, id); !errors.Is(err, ErrTokenNotFound) {
+			t.Errorf("expired token %s: got %v, want %v", id, err, ErrTokenNotFound)
+		}
+	}
+	if len(store.tokens) != 4 || len(store.expiries) != 4 {
+		t.Errorf("got %d tokens and %d expiries, want 4", len(store.tokens), len(store.expiries))
+	}
+
+	// Adding a token again keeps the stored one
+	if err := store.Revoke(ctx, "0", ""); err != nil {
+		t.Fatalf("revoking token: %v", err)
+	}
+	if err := store.Add(ctx, TokenRecord{ID: "0", ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("adding token: %v", err)
+	}
+	if record, _ := store.Get(ctx, "0"); !record.Revoked || len(store.expiries) != 4 {
+		t.Errorf("got %+v and %d expiries, want the revoked token kept", record, len(store.expiries))
+	}
+}
@@ -0,0 +1,117 @@
+package tokenPackage
+
+import (
+	"context"
+	"time"
+
+	"go-chat-application/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoTokenStore is a TokenStore backed by the "tokens" collection, so that
+// revocations survive restarts and are shared by every server replica.
+type MongoTokenStore struct {
+	collection *mongo.Collection
+	// timeout bounds every token store query. Errors caused by it are marked
+	// as database.ErrTimeout.
+	timeout time.Duration
+}
+
+// NewMongoTokenStore creates a token store on the "tokens" collection of the given
+// database. Its indexes are created by the database migrations, including the TTL
+// index on expires_at through which MongoDB removes expired tokens.
+func NewMongoTokenStore(db *mongo.Database, timeout time.Duration) *MongoTokenStore {
+	return &MongoTokenStore{collection: db.Collection("tokens"), timeout: timeout}
+}
+
+func (s *MongoTokenStore) Add(ctx context.Context, record TokenRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Only insert the token if it is not stored yet
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": record.ID},
+		bson.M{"$setOnInsert": record},
+		options.Update().SetUpsert(true))
+	return database.WrapTimeout("TokenStore.Add", err)
+}
+
+func (s *MongoTokenStore) Get(ctx context.Context, id string) (TokenRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var record TokenRecord
+	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return TokenRecord{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return TokenRecord{}, database.WrapTimeout("TokenStore.Get", err)
+	}
+	return record, nil
+}
+
+func (s *MongoTokenStore) Revoke(ctx context.Context, id, replacedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	set := bson.M{"revoked": true}
+	if replacedBy != "" {
+		set["replaced_by"] = replacedBy
+	}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		return database.WrapTimeout("TokenStore.Revoke", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func (s *MongoTokenStore) RevokeIfActive(ctx context.Context, id, replacedBy string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	set := bson.M{"revoked": true}
+	if replacedBy != "" {
+		set["replaced_by"] = replacedBy
+	}
+
+	// Only match the token while it is active, so that a single caller wins
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id, "revoked": false}, bson.M{"$set": set})
+	if err != nil {
+		return false, database.WrapTimeout("TokenStore.RevokeIfActive", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (s *MongoTokenStore) RevokeSession(ctx context.Context, sessionID string) error {
+	return s.revokeWhere(ctx, bson.M{"session_id": sessionID})
+}
+
+func (s *MongoTokenStore) RevokeSubject(ctx context.Context, subject string) error {
+	return s.revokeWhere(ctx, bson.M{"subject": subject})
+}
+
+func (s *MongoTokenStore) Size(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// The TTL monitor only runs periodically, so skip tokens that expired since
+	size, err := s.collection.CountDocuments(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	return size, database.WrapTimeout("TokenStore.Size", err)
+}
+
+// revokeWhere revokes every stored token matching the filter.
+func (s *MongoTokenStore) revokeWhere(ctx context.Context, filter bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return database.WrapTimeout("TokenStore.Revoke", err)
+}
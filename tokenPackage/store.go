@@ -0,0 +1,57 @@
+package tokenPackage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTokenNotFound is returned when no stored token matches the given ID.
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenRecord is the server-side state kept for every issued token.
+type TokenRecord struct {
+	ID         string    `bson:"_id"`
+	TokenType  string    `bson:"token_type"`
+	Subject    string    `bson:"subject"`
+	SessionID  string    `bson:"session_id"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+	Revoked    bool      `bson:"revoked"`
+	ReplacedBy string    `bson:"replaced_by,omitempty"`
+}
+
+// TokenStore keeps track of issued tokens and their revocation state.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Add stores a newly issued token. Adding a token that is already stored is a no-op.
+	Add(ctx context.Context, record TokenRecord) error
+	// Get returns the stored token with the given ID, or ErrTokenNotFound.
+	Get(ctx context.Context, id string) (TokenRecord, error)
+	// Revoke marks the token with the given ID as revoked. If the token was revoked
+	// because it was exchanged for a new one, replacedBy holds the new token's ID.
+	Revoke(ctx context.Context, id, replacedBy string) error
+	// RevokeIfActive revokes the token with the given ID only if it is not revoked
+	// yet, in a single atomic step, and reports whether it did. It lets exactly one
+	// of several concurrent refreshes exchange a refresh token.
+	RevokeIfActive(ctx context.Context, id, replacedBy string) (bool, error)
+	// RevokeSession revokes every token issued for the given session.
+	RevokeSession(ctx context.Context, sessionID string) error
+	// RevokeSubject revokes every token issued to the given subject.
+	RevokeSubject(ctx context.Context, subject string) error
+	// Size returns the number of stored tokens that have not expired yet.
+	Size(ctx context.Context) (int64, error)
+}
+
+// RecordFromClaims builds the stored representation of a token from its claims.
+func RecordFromClaims(claims *Claims) TokenRecord {
+	record := TokenRecord{
+		ID:        claims.ID,
+		TokenType: claims.TokenType,
+		Subject:   claims.UserID,
+		SessionID: claims.SessionID,
+	}
+	if claims.ExpiresAt != nil {
+		record.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return record
+}
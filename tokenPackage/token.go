@@ -1,19 +1,28 @@
 package tokenPackage
 
 import (
+	"context"
 	"errors"
-	"go-chat-application/config"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var TokenMap = make(map[string]*jwt.Token)
+var tracer = otel.Tracer("go-chat-application/tokenPackage")
 
-func AddTokenToMap(token *jwt.Token) {
-	id := token.Claims.(jwt.MapClaims)["ID"].(string)
-	TokenMap[id] = token
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
 // ExtractJWTTokenFromHeader extracts the JWT token from the Authorization header of the HTTP request.
@@ -42,29 +51,170 @@ func ExtractJWTTokenFromHeader(r *http.Request) string {
 	return ""
 }
 
-// ParseAndValidateJWTToken parses and validates the JWT token string.
-func ParseAndValidateJWTToken(tokenString string) (*jwt.Token, error) {
+// TokenService issues and verifies the tokens of one application instance.
+type TokenService struct {
+	// Store keeps track of issued tokens and their revocation state.
+	Store TokenStore
+	// Keys holds the asymmetric signing keys. When it is nil, tokens are signed
+	// and verified with HS256 and Secret.
+	Keys *KeySet
+	// Secret is the shared HS256 secret. When Keys is set it is only used to
+	// verify tokens issued before the switch to asymmetric keys.
+	Secret []byte
+	// LegacyTokenCompatibility controls whether tokens issued with the old custom
+	// claim names are still accepted. Legacy tokens live at most as long as a
+	// refresh token, so this can be turned off once that much time has passed
+	// since the typed claims were deployed.
+	LegacyTokenCompatibility bool
+	// LegacyTokenMaxAge is how long after they were issued legacy tokens are still
+	// accepted. Legacy tokens cannot be revoked by session, so this closes the
+	// compatibility window even while LegacyTokenCompatibility is on.
+	LegacyTokenMaxAge time.Duration
+}
+
+// DefaultLegacyTokenMaxAge is the LegacyTokenMaxAge of new token services, the
+// lifetime of the refresh tokens issued before typed claims.
+const DefaultLegacyTokenMaxAge = 7 * 24 * time.Hour
+
+// NewTokenService creates a token service that signs tokens with the given keys,
+// or with the secret when keys is nil.
+func NewTokenService(store TokenStore, keys *KeySet, secret string) *TokenService {
+	return &TokenService{
+		Store:                    store,
+		Keys:                     keys,
+		Secret:                   []byte(secret),
+		LegacyTokenCompatibility: true,
+		LegacyTokenMaxAge:        DefaultLegacyTokenMaxAge,
+	}
+}
+
+// IssueToken creates a token of the given type for the user, adds it to the token
+// store and signs it. It returns the signed token and its claims.
+func (s *TokenService) IssueToken(ctx context.Context, tokenType, userID, sessionID string,
+	expiration time.Duration) (string, *Claims, error) {
+	// Define the claims for the token
+	claims, err := NewClaims(tokenType, userID, sessionID, expiration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	signedToken, err := s.IssueClaims(ctx, claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signedToken, claims, nil
+}
+
+// IssueClaims adds the token with the given claims to the token store and signs it.
+// It lets callers know the ID of a token before it is issued.
+func (s *TokenService) IssueClaims(ctx context.Context, claims *Claims) (_ string, err error) {
+	ctx, span := tracer.Start(ctx, "TokenService.IssueToken",
+		trace.WithAttributes(attribute.String("jwt.token_type", claims.TokenType)))
+	defer func() { endSpan(span, err) }()
+
+	// Add the token to the token store
+	if err := s.Store.Add(ctx, RecordFromClaims(claims)); err != nil {
+		return "", err
+	}
+
+	// Sign the token with the current signing key, falling back to the JWT secret
+	var signedToken string
+	if s.Keys != nil {
+		token := jwt.NewWithClaims(s.Keys.Signing.Method, claims)
+		token.Header["kid"] = s.Keys.Signing.ID
+		span.SetAttributes(attribute.String("jwt.alg", token.Method.Alg()),
+			attribute.String("jwt.kid", s.Keys.Signing.ID))
+		signedToken, err = token.SignedString(s.Keys.Signing.PrivateKey)
+	} else {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		span.SetAttributes(attribute.String("jwt.alg", token.Method.Alg()))
+		signedToken, err = token.SignedString(s.Secret)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return signedToken, nil
+}
+
+// ParseAndValidateJWTToken parses the JWT token string and validates its signature,
+// issuer, audience and expiry. Tokens issued with the old custom claim names are
+// accepted while LegacyTokenCompatibility is enabled, for LegacyTokenMaxAge.
+func (s *TokenService) ParseAndValidateJWTToken(ctx context.Context, tokenString string) (_ *Claims, err error) {
+	_, span := tracer.Start(ctx, "TokenService.ParseAndValidateJWTToken")
+	defer func() { endSpan(span, err) }()
+
 	// If the token string is empty, return an error.
 	if tokenString == "" {
 		return nil, errors.New("no token provided")
 	}
 
-	// Parse the token string.
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// If the token's signing method is not HMAC, return an error.
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
+	// Parse the token string into typed claims.
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc,
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+	)
 
-		// If the token's signing method is HMAC, return the JWT secret as the key.
-		return []byte(config.ApiCfg.JwtSecret), nil
-	})
+	if token != nil && token.Method != nil {
+		span.SetAttributes(attribute.String("jwt.alg", token.Method.Alg()))
+	}
+
+	// If parsing the token string succeeded, return the claims.
+	if err == nil {
+		span.SetAttributes(attribute.String("jwt.token_type", claims.TokenType))
+		return claims, nil
+	}
+
+	// Fall back to the old claim names for tokens issued before typed claims.
+	if s.LegacyTokenCompatibility && !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		legacyClaims := jwt.MapClaims{}
+		_, legacyErr := jwt.ParseWithClaims(tokenString, legacyClaims, s.keyFunc,
+			jwt.WithValidMethods(validMethods))
+		if legacyErr == nil {
+			if _, isLegacy := legacyClaims["Issuer"]; isLegacy {
+				span.SetAttributes(attribute.Bool("jwt.legacy", true))
+				return claimsFromLegacy(legacyClaims, time.Now().Add(-s.LegacyTokenMaxAge))
+			}
+		}
+	}
 
 	// If parsing the token string failed, return the error.
-	if err != nil {
-		return nil, err
+	return nil, err
+}
+
+// validMethods lists the signing algorithms accepted when parsing tokens.
+var validMethods = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// keyFunc returns the key used to verify the signature of the token.
+func (s *TokenService) keyFunc(token *jwt.Token) (interface{}, error) {
+	// If the token's signing method is HMAC, return the JWT secret as the key.
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(s.Secret) == 0 {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		return s.Secret, nil
 	}
 
-	// If parsing the token string succeeded, return the token.
-	return token, nil
+	// Otherwise look up the verification key named by the "kid" header.
+	if s.Keys == nil {
+		return nil, errors.New("invalid signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.Keys.Verification[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	// The token must be signed with the algorithm that belongs to the key.
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+	return key.PublicKey, nil
 }